@@ -0,0 +1,105 @@
+package sqllogging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// AssertionFailure is reported from SQL with the "assert:" prefix, for
+// invariant checks that should fail loudly in tests without aborting the
+// batch as raising an error would:
+//
+//	assert:cond=[balance >= 0] account=42 Balance went negative
+type AssertionFailure struct {
+	Condition string
+	Message   string
+	Fields    logrus.Fields // all fields as parsed, including cond
+}
+
+func (f AssertionFailure) String() string {
+	if f.Message == "" {
+		return "assertion failed: " + f.Condition
+	}
+	return "assertion failed: " + f.Condition + ": " + f.Message
+}
+
+// AssertionError is returned by CheckAssertions.
+type AssertionError struct {
+	Failures []AssertionFailure
+}
+
+func (e AssertionError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = f.String()
+	}
+	return fmt.Sprintf("%d SQL assertion(s) failed: %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+type assertions struct {
+	mu       sync.Mutex
+	failures []AssertionFailure
+}
+
+// WithAssertions makes assertion failures from SQL be recorded on the
+// context, to be retrieved with Failures. Assertion failures are always
+// logged at error level.
+func WithAssertions(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ckAssertions, &assertions{})
+}
+
+func assertionsOrNil(ctx context.Context) *assertions {
+	val := ctx.Value(ckAssertions)
+	if val == nil {
+		return nil
+	}
+	return val.(*assertions)
+}
+
+// Failures returns the assertion failures recorded so far on ctx, which
+// must come from WithAssertions.
+func Failures(ctx context.Context) []AssertionFailure {
+	a := assertionsOrNil(ctx)
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]AssertionFailure(nil), a.failures...)
+}
+
+// CheckAssertions calls f with a context recording assertion failures, and
+// returns an AssertionError if f did not return an error but assertions
+// failed.
+func CheckAssertions(ctx context.Context, f func(ctx context.Context) error) error {
+	ctx = WithAssertions(ctx)
+	err := f(ctx)
+	if err != nil {
+		return err
+	}
+	if failures := Failures(ctx); len(failures) > 0 {
+		return AssertionError{Failures: failures}
+	}
+	return nil
+}
+
+func init() {
+	RegisterPrefix("assert", assertHandler)
+}
+
+func assertHandler(ctx context.Context, l LogrusLogger, m Message) {
+	failure := AssertionFailure{Message: m.Text, Fields: m.Fields}
+	failure.Condition, _ = m.Fields["cond"].(string)
+
+	m.Logger.WithFields(m.Fields).Error(failure.String())
+
+	if a := assertionsOrNil(ctx); a != nil {
+		a.mu.Lock()
+		a.failures = append(a.failures, failure)
+		a.mu.Unlock()
+	}
+}
@@ -0,0 +1,38 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssertions(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}}
+
+	err := CheckAssertions(context.Background(), func(ctx context.Context) error {
+		logger.Log(ctx, msdsn.LogMessages, "assert:cond=[balance >= 0] account=42 Balance went negative")
+		assert.Equal(t, []AssertionFailure{{
+			Condition: "balance >= 0",
+			Message:   "Balance went negative",
+			Fields:    logrus.Fields{"cond": "balance >= 0", "account": 42},
+		}}, Failures(ctx))
+		return nil
+	})
+	require.Error(t, err)
+	assert.Equal(t, "1 SQL assertion(s) failed: assertion failed: balance >= 0: Balance went negative", err.Error())
+	assert.Equal(t,
+		`{"account":42,"cond":"balance \u003e= 0","level":"error","msg":"assertion failed: balance \u003e= 0: Balance went negative"}`+"\n",
+		logbuf.String())
+
+	assert.NoError(t, CheckAssertions(context.Background(), func(ctx context.Context) error { return nil }))
+	assert.Nil(t, Failures(context.Background()))
+}
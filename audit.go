@@ -0,0 +1,246 @@
+package sqllogging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord is written for every message from SQL with the "audit:"
+// prefix. Records are hash-chained: Hash covers all the other fields,
+// including the Hash of the previous record in PrevHash, so that tampering
+// with or dropping records can be detected with VerifyAuditChain. Seq
+// increases by one for every record, starting at 1, and the first record
+// of a chain has AuditGenesisHash as PrevHash. A chain continues across
+// process runs if the sink can tell where it ended.
+type AuditRecord struct {
+	Seq      uint64         `json:"seq"`
+	Time     time.Time      `json:"time"`
+	Message  string         `json:"msg"`
+	Fields   map[string]any `json:"fields,omitempty"`
+	PrevHash string         `json:"prev_hash"`
+	Hash     string         `json:"hash"`
+}
+
+// AuditGenesisHash is the PrevHash of the first record of a chain
+var AuditGenesisHash = strings.Repeat("0", 64)
+
+// AuditHead identifies the last record written to a chain. Store it apart
+// from the records, so that VerifyAuditChain can detect records dropped at
+// the end.
+type AuditHead struct {
+	Seq  uint64
+	Hash string
+}
+
+// AuditSink stores audit records. Audit records are never written to the
+// normal logger. If the sink also has a method
+//
+//	Head() AuditHead
+//
+// returning the last record it holds, as FileAuditSink does, a new
+// AuditChain continues from it, so that records dropped from an earlier
+// process run are detected too.
+type AuditSink interface {
+	WriteAudit(ctx context.Context, record AuditRecord) error
+}
+
+type auditHeadReader interface {
+	Head() AuditHead
+}
+
+// AuditChain assigns sequence numbers and hashes to audit records and writes
+// them to a sink. Use one per process:
+//
+//	sqllogging.RegisterPrefix("audit", sqllogging.NewAuditChain(sink).Handler)
+//
+// Until then, audit messages are dropped with an error logged.
+type AuditChain struct {
+	sink AuditSink
+
+	mu       sync.Mutex
+	seq      uint64
+	prevHash string
+	now      func() time.Time
+}
+
+func NewAuditChain(sink AuditSink) *AuditChain {
+	c := &AuditChain{sink: sink, prevHash: AuditGenesisHash}
+	if r, ok := sink.(auditHeadReader); ok {
+		if head := r.Head(); head.Seq != 0 {
+			c.seq, c.prevHash = head.Seq, head.Hash
+		}
+	}
+	return c
+}
+
+// Head returns the last record written; the zero AuditHead if none.
+func (c *AuditChain) Head() AuditHead {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.seq == 0 {
+		return AuditHead{}
+	}
+	return AuditHead{Seq: c.seq, Hash: c.prevHash}
+}
+
+func init() {
+	RegisterPrefix("audit", func(ctx context.Context, l LogrusLogger, m Message) {
+		m.Logger.Error("Audit record from SQL dropped; no audit sink configured")
+	})
+}
+
+// Handler is a PrefixHandler for the "audit:" prefix.
+func (c *AuditChain) Handler(ctx context.Context, l LogrusLogger, m Message) {
+	err := c.Write(ctx, m.Text, m.Fields)
+	if err != nil {
+		// Only the error; the record itself must not go to the normal logger
+		m.Logger.Error("Failed to write audit record from SQL: " + err.Error())
+	}
+}
+
+// Write adds a record to the chain and writes it to the sink. If writing
+// fails the record still takes its place in the chain, so the loss is
+// detectable.
+func (c *AuditChain) Write(ctx context.Context, msg string, fields map[string]any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.now != nil {
+		now = c.now()
+	}
+	c.seq++
+	record := AuditRecord{
+		Seq:      c.seq,
+		Time:     now.UTC(),
+		Message:  msg,
+		Fields:   fields,
+		PrevHash: c.prevHash,
+	}
+	hash, err := auditHash(record)
+	if err != nil {
+		return err
+	}
+	record.Hash = hash
+	c.prevHash = hash
+	return c.sink.WriteAudit(ctx, record)
+}
+
+func auditHash(record AuditRecord) (string, error) {
+	record.Hash = ""
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyAuditChain reads audit records written as JSON lines, e.g. by
+// FileAuditSink, and returns an error describing the first record that has
+// been tampered with or is missing. The records must form a single chain,
+// starting at seq 1. head is the Head of the chain; if it is the zero
+// AuditHead, records dropped after the last one read cannot be detected.
+func VerifyAuditChain(r io.Reader, head AuditHead) error {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var prev AuditRecord
+	for {
+		var record AuditRecord
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch {
+		case prev.Seq == 0 && record.Seq == 1:
+			if record.PrevHash != AuditGenesisHash {
+				return fmt.Errorf("audit record seq 1 does not start a chain")
+			}
+		case prev.Seq == 0:
+			return fmt.Errorf("audit record(s) missing before seq %d", record.Seq)
+		case record.Seq == 1:
+			return fmt.Errorf("audit chain restarted after seq %d", prev.Seq)
+		case record.Seq != prev.Seq+1:
+			return fmt.Errorf("audit record(s) missing between seq %d and %d", prev.Seq, record.Seq)
+		case record.PrevHash != prev.Hash:
+			return fmt.Errorf("audit record seq %d does not follow seq %d", record.Seq, prev.Seq)
+		}
+		hash, err := auditHash(record)
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return fmt.Errorf("audit record seq %d has been modified", record.Seq)
+		}
+		prev = record
+	}
+	if head.Seq != 0 && (prev.Seq != head.Seq || prev.Hash != head.Hash) {
+		return fmt.Errorf("audit record(s) missing after seq %d; the head is seq %d", prev.Seq, head.Seq)
+	}
+	return nil
+}
+
+// FileAuditSink appends audit records to a local file as JSON lines. The
+// file holds a single chain; a new AuditChain continues from the last
+// record in it.
+type FileAuditSink struct {
+	mu   sync.Mutex
+	file *os.File
+	head AuditHead
+}
+
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileAuditSink{file: file}
+	dec := json.NewDecoder(file)
+	for {
+		var record AuditRecord
+		err := dec.Decode(&record)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			_ = file.Close()
+			return nil, fmt.Errorf("reading the last audit record of %s: %w", path, err)
+		}
+		s.head = AuditHead{Seq: record.Seq, Hash: record.Hash}
+	}
+	return s, nil
+}
+
+// Head returns the last record in the file
+func (s *FileAuditSink) Head() AuditHead {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.head
+}
+
+func (s *FileAuditSink) WriteAudit(ctx context.Context, record AuditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	if err != nil {
+		return err
+	}
+	s.head = AuditHead{Seq: record.Seq, Hash: record.Hash}
+	return s.file.Sync()
+}
+
+func (s *FileAuditSink) Close() error {
+	return s.file.Close()
+}
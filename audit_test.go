@@ -0,0 +1,78 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileAuditSink(path)
+	require.NoError(t, err)
+
+	chain := NewAuditChain(sink)
+	chain.now = func() time.Time { return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC) }
+
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	ctx := context.Background()
+	m := Message{Category: msdsn.LogMessages, Prefix: "audit", Logger: log}
+	for _, raw := range []string{"user=[alice] amount=100 Transferred", "user=[bob] Deleted account", "Closed period"} {
+		m.Fields, m.Text = parseFields(raw)
+		chain.Handler(ctx, LogrusLogger{Logger: log}, m)
+	}
+	require.NoError(t, sink.Close())
+	head := chain.Head()
+	assert.Equal(t, uint64(3), head.Seq)
+	assert.Equal(t, "", logbuf.String())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	assert.Contains(t, lines[0], `"seq":1,"time":"2000-01-01T00:00:00Z","msg":"Transferred","fields":{"amount":100,"user":"alice"},"prev_hash":"`+AuditGenesisHash+`"`)
+	assert.NoError(t, VerifyAuditChain(strings.NewReader(string(data)), head))
+	// A chain must not start over in the same file
+	assert.EqualError(t, VerifyAuditChain(strings.NewReader(string(data)+string(data)), head), "audit chain restarted after seq 3")
+
+	tampered := strings.Replace(string(data), `"amount":100`, `"amount":1000`, 1)
+	assert.EqualError(t, VerifyAuditChain(strings.NewReader(tampered), head), "audit record seq 1 has been modified")
+
+	dropped := lines[0] + lines[2]
+	assert.EqualError(t, VerifyAuditChain(strings.NewReader(dropped), head), "audit record(s) missing between seq 1 and 3")
+	dropped = lines[1] + lines[2]
+	assert.EqualError(t, VerifyAuditChain(strings.NewReader(dropped), head), "audit record(s) missing before seq 2")
+	dropped = lines[0] + lines[1]
+	assert.EqualError(t, VerifyAuditChain(strings.NewReader(dropped), head), "audit record(s) missing after seq 2; the head is seq 3")
+	assert.NoError(t, VerifyAuditChain(strings.NewReader(dropped), AuditHead{}))
+
+	// After a restart, the chain continues from the last record in the
+	// file, so that records dropped from the earlier run are detected
+	sink, err = NewFileAuditSink(path)
+	require.NoError(t, err)
+	assert.Equal(t, head, sink.Head())
+	chain = NewAuditChain(sink)
+	require.NoError(t, chain.Write(ctx, "Reopened period", nil))
+	require.NoError(t, sink.Close())
+	head = chain.Head()
+	assert.Equal(t, uint64(4), head.Seq)
+
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	lines = strings.SplitAfter(string(data), "\n")
+	assert.NoError(t, VerifyAuditChain(strings.NewReader(string(data)), head))
+	truncated := lines[0] + lines[1] + lines[3]
+	assert.EqualError(t, VerifyAuditChain(strings.NewReader(truncated), head), "audit record(s) missing between seq 2 and 4")
+	truncated = lines[3]
+	assert.EqualError(t, VerifyAuditChain(strings.NewReader(truncated), head), "audit record(s) missing before seq 4")
+}
@@ -10,7 +10,16 @@ import (
 
 type contextKey int
 
-const ckLogger contextKey = iota
+const (
+	ckLogger contextKey = iota
+	ckQueryTracer
+	ckTransactionTracker
+	ckStats
+	ckSpans
+	ckSpanEvents
+	ckProgress
+	ckAssertions
+)
 
 // WithLogger attaches an mssql.ContextLogger to ctx. This is the basic
 // hook and you may use this directly to override the SQL logger per call.
@@ -18,6 +27,28 @@ func WithLogger(ctx context.Context, logger mssql.ContextLogger) context.Context
 	return context.WithValue(ctx, ckLogger, logger)
 }
 
+// internalContext hides the values attached by this package, for the queries
+// the library makes itself, such as table dumps. The driver logs those
+// through the same hook, and they must not end up in the caller's Stats,
+// QueryTracer and so on.
+type internalContext struct {
+	context.Context
+}
+
+func withoutSqllogging(ctx context.Context) context.Context {
+	if _, ok := ctx.(internalContext); ok {
+		return ctx
+	}
+	return internalContext{ctx}
+}
+
+func (c internalContext) Value(key any) any {
+	if _, ok := key.(contextKey); ok {
+		return nil
+	}
+	return c.Context.Value(key)
+}
+
 func LoggerOrNil(ctx context.Context) mssql.ContextLogger {
 	val := ctx.Value(ckLogger)
 	if val == nil {
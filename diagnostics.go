@@ -0,0 +1,84 @@
+package sqllogging
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+)
+
+// Diagnostic recognizes a known SQL Server message and attaches a stable
+// diagnostic_code and a remediation hint to the log entry.
+type Diagnostic struct {
+	Code string
+	Hint string
+	// Match reports whether msg is the message this diagnostic is for.
+	Match func(category msdsn.Log, msg string) bool
+	// Rewrite, if set, replaces the message before it is parsed for a level
+	// prefix and fields; used when SQL Server's own message is unhelpful.
+	Rewrite func(msg string) string
+}
+
+var (
+	diagnosticsMu sync.RWMutex
+	diagnostics   = []Diagnostic{
+		{
+			Code: "sql_format_error",
+			Hint: "Check the format string and argument types passed to formatmessage() or raiserror(); e.g., use %I64d for bigint",
+			Match: func(category msdsn.Log, msg string) bool {
+				return category&msdsn.LogMessages != 0 && strings.HasPrefix(msg, "Error: 50000") &&
+					strings.Contains(msg, "The error is printed in terse mode because there was error during formatting")
+			},
+			Rewrite: func(string) string {
+				return "error:Wrong format string provided to formatmessage()"
+			},
+		},
+		{
+			Code:  "sql_truncation",
+			Hint:  "A value is longer than the target column; widen the column or truncate the value explicitly",
+			Match: messageContains("String or binary data would be truncated"),
+		},
+		{
+			Code:  "sql_deadlock_victim",
+			Hint:  "Retry the transaction; to avoid it, access tables in a consistent order and keep transactions short",
+			Match: messageContains("chosen as the deadlock victim"),
+		},
+		{
+			Code:  "sql_null_eliminated",
+			Hint:  "An aggregate skipped NULL values; use isnull()/coalesce() if NULLs should count, or filter them out explicitly",
+			Match: messageContains("Null value is eliminated by an aggregate or other SET operation"),
+		},
+	}
+)
+
+func messageContains(substr string) func(msdsn.Log, string) bool {
+	return func(_ msdsn.Log, msg string) bool {
+		return strings.Contains(msg, substr)
+	}
+}
+
+// RegisterDiagnostic adds a diagnostic to the catalog. Diagnostics are tried
+// in registration order and the first match wins; the built-in entries come
+// first.
+func RegisterDiagnostic(d Diagnostic) {
+	diagnosticsMu.Lock()
+	defer diagnosticsMu.Unlock()
+	diagnostics = append(diagnostics, d)
+}
+
+// diagnose looks up msg in the catalog, returning the (possibly rewritten)
+// message and the fields to attach, or nil fields if there was no match.
+func diagnose(category msdsn.Log, msg string) (string, logrus.Fields) {
+	diagnosticsMu.RLock()
+	defer diagnosticsMu.RUnlock()
+	for _, d := range diagnostics {
+		if d.Match(category, msg) {
+			if d.Rewrite != nil {
+				msg = d.Rewrite(msg)
+			}
+			return msg, logrus.Fields{"diagnostic_code": d.Code, "diagnostic_hint": d.Hint}
+		}
+	}
+	return msg, nil
+}
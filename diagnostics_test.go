@@ -0,0 +1,56 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiagnostics(t *testing.T) {
+	ctx := context.Background()
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	logger := LogrusLogger{
+		Logger:   log,
+		Fallback: StandardFallbackLogrusMssqlLogger{Mask: msdsn.LogErrors, Level: logrus.WarnLevel},
+	}
+
+	logger.Log(ctx, msdsn.LogMessages, "Error: 50000, Severity: -1, State: 1. (Params:). "+
+		"The error is printed in terse mode because there was error during formatting. Tracing, ETW, notifications etc are skipped.")
+	assert.Equal(t,
+		`{"diagnostic_code":"sql_format_error","diagnostic_hint":"Check the format string and argument types passed to formatmessage() or raiserror(); e.g., use %I64d for bigint","level":"error","msg":"Wrong format string provided to formatmessage()"}`+"\n",
+		logbuf.String())
+
+	logbuf.Reset()
+	diagnosticsMu.RLock()
+	saved := append([]Diagnostic{}, diagnostics...)
+	diagnosticsMu.RUnlock()
+	t.Cleanup(func() {
+		diagnosticsMu.Lock()
+		defer diagnosticsMu.Unlock()
+		diagnostics = saved
+	})
+	RegisterDiagnostic(Diagnostic{
+		Code:  "test_code",
+		Hint:  "test hint",
+		Match: messageContains("custom failure"),
+	})
+	logger.Log(ctx, msdsn.LogErrors, "a custom failure")
+	assert.Equal(t,
+		`{"diagnostic_code":"test_code","diagnostic_hint":"test hint","level":"warning","msg":"a custom failure"}`+"\n",
+		logbuf.String())
+
+	_, fields := diagnose(msdsn.LogErrors, "Transaction (Process ID 52) was deadlocked on lock resources with another process and has been chosen as the deadlock victim. Rerun the transaction.")
+	assert.Equal(t, "sql_deadlock_victim", fields["diagnostic_code"])
+	_, fields = diagnose(msdsn.LogMessages, "Warning: Null value is eliminated by an aggregate or other SET operation.")
+	assert.Equal(t, "sql_null_eliminated", fields["diagnostic_code"])
+	_, fields = diagnose(msdsn.LogMessages, "info:all good")
+	assert.Nil(t, fields)
+}
@@ -0,0 +1,81 @@
+package sqllogging
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// TableDumpPolicy decides which of the ## tables named in log messages are
+// dumped and dropped. By default only tables created by [code].log are:
+// their names are "##log" followed by a random nonce, and [code].log marks
+// them with the extended property sqllogging_nonce, whose value is passed
+// along in the message:
+//
+//	info:##log5f0c... dumpnonce=[a81b...]
+//
+// Other tables, such as an unrelated ##shared_cache, are left alone and a
+// warning is logged instead.
+type TableDumpPolicy struct {
+	// Pattern of the table names that may be dumped; nil means DefaultLogTablePattern
+	Pattern *regexp.Regexp
+	// SkipVerification dumps tables matching Pattern without checking the
+	// mark set by [code].log, e.g. for tables created by older versions of it
+	SkipVerification bool
+	Drop             DropPolicy
+}
+
+// DropPolicy decides what happens to a table after it has been dumped
+type DropPolicy int
+
+const (
+	DropAfterDump DropPolicy = iota // drop the table; the default
+	DropNever                       // leave the table, e.g. to inspect it or to leave it to the Janitor
+)
+
+// DefaultLogTablePattern matches the names of tables created by [code].log
+var DefaultLogTablePattern = regexp.MustCompile(`^##log[0-9a-f]{32}$`)
+
+const sqlLogTableNonce = `
+select convert(varchar(100), value) from tempdb.sys.extended_properties
+where class = 1 and major_id = object_id(@p1) and minor_id = 0 and name = 'sqllogging_nonce'`
+
+func (p TableDumpPolicy) pattern() *regexp.Regexp {
+	if p.Pattern == nil {
+		return DefaultLogTablePattern
+	}
+	return p.Pattern
+}
+
+// verify returns an error unless tablename may be dumped; nonce is the
+// dumpnonce from the message
+func (p TableDumpPolicy) verify(ctx context.Context, querier QuerierExecer, tablename string, nonce string) error {
+	if !p.pattern().MatchString(tablename) {
+		return fmt.Errorf("name does not match %s", p.pattern())
+	}
+	if p.SkipVerification {
+		return nil
+	}
+	if nonce == "" {
+		return errors.New("no dumpnonce in message")
+	}
+	var marked string
+	err := querier.QueryRowContext(withoutSqllogging(ctx), sqlLogTableNonce, "tempdb.."+sqlQuotename(tablename)).Scan(&marked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("table not marked by [code].log")
+	} else if err != nil {
+		return err
+	}
+	if marked != nonce {
+		return errors.New("dumpnonce does not match the mark of the table")
+	}
+	return nil
+}
+
+func (p TableDumpPolicy) drop(ctx context.Context, querier QuerierExecer, tablename string) {
+	if p.Drop == DropAfterDump {
+		dropTable(ctx, querier, tablename)
+	}
+}
@@ -0,0 +1,28 @@
+package sqllogging
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableDumpPolicyVerify(t *testing.T) {
+	ctx := context.Background()
+	var policy TableDumpPolicy
+
+	err := policy.verify(ctx, nil, "##shared_cache", "")
+	assert.EqualError(t, err, `name does not match ^##log[0-9a-f]{32}$`)
+	err = policy.verify(ctx, nil, "##log0123456789abcdef0123456789abcdef", "")
+	assert.EqualError(t, err, "no dumpnonce in message")
+
+	policy = TableDumpPolicy{Pattern: regexp.MustCompile(`^##dump_`), SkipVerification: true}
+	assert.NoError(t, policy.verify(ctx, nil, "##dump_1", ""))
+	assert.Error(t, policy.verify(ctx, nil, "##log0123456789abcdef0123456789abcdef", ""))
+
+	_, opts, ok := parseTableDump("##log0123456789abcdef0123456789abcdef dumpnonce=[n1] limit=5", TableDumpOptions{})
+	assert.True(t, ok)
+	assert.Equal(t, "n1", opts.nonce)
+	assert.Equal(t, 5, opts.Limit)
+}
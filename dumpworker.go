@@ -0,0 +1,191 @@
+package sqllogging
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// DumpWorker runs table dumps outside of the driver's message callback, on
+// a connection reserved for it. Without it, dumps run synchronously in the
+// callback on the DB passed to With, which stalls reading the results of
+// the query, and may deadlock if the pool is exhausted.
+//
+// To keep the order of the log, messages that arrive while dumps are
+// queued are queued behind them; once the queue is empty, messages are
+// handled directly again. The callback never blocks: if the queue is full,
+// messages are dropped with a warning and counted in Dropped, and the
+// ##log tables of dropped dumps are left for the Janitor.
+//
+// SQL Server drops a ## table once the session that created it has ended
+// and no other session uses it. A dump still queued by then fails with a
+// warning; call Flush before the connection of the query is returned to
+// the pool to make sure its dumps have run.
+//
+// If the reserved connection breaks, a new one is taken from the DB before
+// the next dump.
+type DumpWorker struct {
+	// Timeout of each dump, which is detached from the context of the
+	// query; 0 means DefaultDumpTimeout
+	Timeout time.Duration
+
+	dbi     DB
+	conn    *sql.Conn // only used by the worker goroutine, and Close
+	querier QuerierExecer
+	size    int
+	done    chan struct{}
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	jobs     []func()
+	running  bool // a job is running
+	closed   bool
+	dropped  int64
+	dropping bool // messages have been dropped since the last one was queued
+}
+
+const DefaultDumpTimeout = 30 * time.Second
+
+// NewDumpWorker reserves a connection from dbi and starts a worker with
+// room for queueSize messages. Set it as LogrusLogger.DumpWorker, and
+// Close it when done.
+func NewDumpWorker(ctx context.Context, dbi DB, queueSize int) (*DumpWorker, error) {
+	conn, err := dbi.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := newDumpWorker(conn, queueSize)
+	w.dbi, w.conn = dbi, conn
+	return w, nil
+}
+
+func newDumpWorker(querier QuerierExecer, queueSize int) *DumpWorker {
+	w := &DumpWorker{
+		querier: querier,
+		size:    queueSize,
+		done:    make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	go w.work()
+	return w
+}
+
+func (w *DumpWorker) work() {
+	defer close(w.done)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		for len(w.jobs) == 0 {
+			if w.closed {
+				return
+			}
+			w.cond.Wait()
+		}
+		job := w.jobs[0]
+		w.jobs = w.jobs[1:]
+		w.running = true
+		w.mu.Unlock()
+		job()
+		w.mu.Lock()
+		w.running = false
+	}
+}
+
+func (w *DumpWorker) timeout() time.Duration {
+	if w.Timeout <= 0 {
+		return DefaultDumpTimeout
+	}
+	return w.Timeout
+}
+
+// reconnect replaces the reserved connection if it is broken. If no new
+// one can be had, the broken one is kept, so that the dump fails with an
+// error, and the next dump tries again.
+func (w *DumpWorker) reconnect(ctx context.Context) {
+	if w.dbi == nil || w.conn.PingContext(ctx) == nil {
+		return
+	}
+	conn, err := w.dbi.Conn(ctx)
+	if err != nil {
+		return
+	}
+	_ = w.conn.Close()
+	w.conn, w.querier = conn, conn
+}
+
+// submit handles a message with f, either directly or on the worker; dumps
+// are always handled on the worker, other messages only if dumps are queued
+func (w *DumpWorker) submit(ctx context.Context, l LogrusLogger, isDump bool, f func(ctx context.Context, l LogrusLogger)) {
+	w.mu.Lock()
+	if w.closed || (!isDump && len(w.jobs) == 0 && !w.running) {
+		w.mu.Unlock()
+		f(ctx, l)
+		return
+	}
+	if len(w.jobs) >= w.size {
+		w.dropped++
+		warn := isDump || !w.dropping
+		w.dropping = true
+		w.mu.Unlock()
+		if isDump {
+			l.Logger.Warning("Unable to log table: dump queue is full")
+		} else if warn {
+			l.Logger.Warning("Dropping log messages from SQL: dump queue is full")
+		}
+		return
+	}
+	w.dropping = false
+
+	timeout := w.timeout()
+	w.jobs = append(w.jobs, func() {
+		jobCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+		if isDump {
+			w.reconnect(jobCtx)
+		}
+		l.Querier = w.querier
+		f(jobCtx, l)
+	})
+	w.cond.Signal()
+	w.mu.Unlock()
+}
+
+// Dropped returns the number of messages dropped because the queue was full.
+func (w *DumpWorker) Dropped() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dropped
+}
+
+// Flush waits until all messages queued so far have been handled.
+func (w *DumpWorker) Flush() {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	flushed := make(chan struct{})
+	w.jobs = append(w.jobs, func() { close(flushed) })
+	w.cond.Signal()
+	w.mu.Unlock()
+	<-flushed
+}
+
+// Close handles the messages still queued, and releases the connection.
+// Messages that arrive later are handled directly.
+func (w *DumpWorker) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.cond.Signal()
+	w.mu.Unlock()
+	<-w.done
+	if w.conn != nil {
+		return w.conn.Close()
+	}
+	return nil
+}
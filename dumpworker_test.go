@@ -0,0 +1,117 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingQuerier fails all queries, after release is closed or the
+// context is done
+type blockingQuerier struct {
+	release chan struct{}
+}
+
+func (q blockingQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	select {
+	case <-q.release:
+		return nil, errors.New("boom")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q blockingQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	panic("not implemented")
+}
+
+func (q blockingQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, nil
+}
+
+func TestDumpWorker(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	querier := blockingQuerier{release: make(chan struct{})}
+	worker := newDumpWorker(querier, 3)
+	defer worker.Close()
+	logger := LogrusLogger{
+		Logger:          log,
+		Fallback:        StandardFallbackLogrusMssqlLogger{},
+		Sticky:          &StickyFields{},
+		TableDumpPolicy: TableDumpPolicy{Pattern: regexp.MustCompile(`^##dump`), SkipVerification: true},
+		DumpWorker:      worker,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	send := func(msg string) {
+		logger.Log(ctx, msdsn.LogMessages, msg)
+	}
+
+	// Without dumps queued, messages are handled directly
+	send("info:before")
+	assert.Equal(t, `{"level":"info","msg":"before"}`+"\n", logbuf.String())
+	logbuf.Reset()
+
+	// Messages after a dump wait for it, and the dump is not cancelled
+	// with the query
+	send("info:##dump1")
+	assert.Eventually(t, func() bool {
+		worker.mu.Lock()
+		defer worker.mu.Unlock()
+		return worker.running && len(worker.jobs) == 0
+	}, time.Second, time.Millisecond)
+	send("info:after")
+	// Sticky fields are set when the message is handled, not when queued
+	send("fields:set batch=1")
+	send("info:##dump2")
+	cancel()
+	assert.Equal(t, "", logbuf.String())
+
+	// The queue is full; one warning for a run of dropped messages, and
+	// one for every dump
+	send("info:dropped")
+	send("debug:dropped")
+	send("info:##dump3")
+	assert.Equal(t, `{"level":"warning","msg":"Dropping log messages from SQL: dump queue is full"}
+{"level":"warning","msg":"Unable to log table: dump queue is full"}
+`, logbuf.String())
+	assert.Equal(t, int64(3), worker.Dropped())
+	logbuf.Reset()
+
+	close(querier.release)
+	worker.Flush()
+	assert.Equal(t, `{"level":"warning","msg":"Unable to log table ##dump1: boom"}
+{"level":"info","msg":"after"}
+{"batch":1,"level":"warning","msg":"Unable to log table ##dump2: boom"}
+`, logbuf.String())
+}
+
+func TestDumpWorkerTimeout(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	worker := newDumpWorker(blockingQuerier{release: make(chan struct{})}, 10)
+	worker.Timeout = 10 * time.Millisecond
+	logger := LogrusLogger{
+		Logger:          log,
+		Fallback:        StandardFallbackLogrusMssqlLogger{},
+		TableDumpPolicy: TableDumpPolicy{Pattern: regexp.MustCompile(`^##dump`), SkipVerification: true},
+		DumpWorker:      worker,
+	}
+	logger.Log(context.Background(), msdsn.LogMessages, "info:##dump1")
+	assert.NoError(t, worker.Close())
+	assert.Equal(t, `{"level":"warning","msg":"Unable to log table ##dump1: context deadline exceeded"}`+"\n", logbuf.String())
+}
@@ -0,0 +1,121 @@
+package sqllogging
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+)
+
+// KnownErrorLevels lists the levels of some common system errors, for
+// ErrorNumberFallbackLogrusMssqlLogger. The table may be extended at init
+// time.
+var KnownErrorLevels = map[int32]logrus.Level{
+	701:  logrus.ErrorLevel, // insufficient system memory
+	823:  logrus.ErrorLevel, // I/O error
+	824:  logrus.ErrorLevel, // logical consistency-based I/O error
+	1105: logrus.ErrorLevel, // could not allocate space in filegroup
+	1205: logrus.WarnLevel,  // deadlock victim
+	1222: logrus.WarnLevel,  // lock request time out
+	2627: logrus.WarnLevel,  // unique/primary key violation
+	2601: logrus.WarnLevel,  // duplicate key row in unique index
+	8152: logrus.WarnLevel,  // string or binary data would be truncated
+	9002: logrus.ErrorLevel, // transaction log full
+}
+
+// ErrorNumberFallbackLogrusMssqlLogger is a fallback that derives the log
+// level of messages without a "level:" prefix from their error number,
+// through Overrides and then KnownErrorLevels.
+//
+// The driver passes neither the severity nor the number of a message to the
+// log hook. Error numbers are only known if LogDebug is included in the
+// "log=" DSN parameter; the driver then emits "got ERROR <number> <msg>"
+// right before the message itself, which is picked up here and otherwise
+// discarded. Messages with other numbers, or without one, are logged at
+// MessageLevel or ErrorLevel, depending on whether they arrived as
+// messages or errors.
+//
+// The zero value logs nothing; use NewErrorNumberFallbackLogrusMssqlLogger.
+type ErrorNumberFallbackLogrusMssqlLogger struct {
+	Mask         msdsn.Log
+	MessageLevel logrus.Level // for msdsn.LogMessages
+	ErrorLevel   logrus.Level // for msdsn.LogErrors
+	// Overrides sets the level of specific error numbers, taking precedence
+	// over KnownErrorLevels.
+	Overrides map[int32]logrus.Level
+
+	mu      sync.Mutex
+	pending map[string]int32 // message -> error number, from LogDebug
+}
+
+func NewErrorNumberFallbackLogrusMssqlLogger() *ErrorNumberFallbackLogrusMssqlLogger {
+	return &ErrorNumberFallbackLogrusMssqlLogger{
+		Mask:         msdsn.LogErrors | msdsn.LogMessages,
+		MessageLevel: logrus.InfoLevel,
+		ErrorLevel:   logrus.WarnLevel,
+	}
+}
+
+// maxPending bounds the number of error numbers remembered for messages that
+// never reach the fallback (e.g. because they had a level prefix).
+const maxPending = 64
+
+func (s *ErrorNumberFallbackLogrusMssqlLogger) Log(ctx context.Context, logger logrus.FieldLogger, category msdsn.Log, msg string) {
+	if category == msdsn.LogDebug {
+		if number, text, ok := parseDebugMessageNumber(msg); ok {
+			s.mu.Lock()
+			if s.pending == nil || len(s.pending) >= maxPending {
+				s.pending = make(map[string]int32)
+			}
+			s.pending[text] = number
+			s.mu.Unlock()
+		}
+		return
+	}
+	if s.Mask&category == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	number, hasNumber := s.pending[msg]
+	delete(s.pending, msg)
+	s.mu.Unlock()
+
+	level := s.MessageLevel
+	if category != msdsn.LogMessages {
+		level = s.ErrorLevel
+	}
+	if hasNumber {
+		logger = logger.WithField("sql_error_number", number)
+		if override, ok := s.Overrides[number]; ok {
+			level = override
+		} else if known, ok := KnownErrorLevels[number]; ok {
+			level = known
+		}
+	}
+	logAtLevel(logger, level, msg)
+}
+
+// parseDebugMessageNumber parses the "got ERROR <number> <msg>" and
+// "got INFO <number> <msg>" lines emitted by the driver under LogDebug.
+func parseDebugMessageNumber(msg string) (number int32, text string, ok bool) {
+	rest, found := strings.CutPrefix(msg, "got ERROR ")
+	if !found {
+		rest, found = strings.CutPrefix(msg, "got INFO ")
+		if !found {
+			return 0, "", false
+		}
+	}
+	numstr, text, found := strings.Cut(rest, " ")
+	if !found {
+		return 0, "", false
+	}
+	n, err := strconv.ParseInt(numstr, 10, 32)
+	if err != nil {
+		return 0, "", false
+	}
+	return int32(n), text, true
+}
@@ -0,0 +1,41 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorNumberFallback(t *testing.T) {
+	ctx := context.Background()
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Level = logrus.DebugLevel
+	log.Formatter = &logrus.TextFormatter{DisableTimestamp: true}
+
+	f := NewErrorNumberFallbackLogrusMssqlLogger()
+	f.Overrides = map[int32]logrus.Level{50001: logrus.DebugLevel}
+
+	f.Log(ctx, log, msdsn.LogMessages, "plain message")
+	assert.Equal(t, "level=info msg=\"plain message\"\n", logbuf.String())
+
+	logbuf.Reset()
+	f.Log(ctx, log, msdsn.LogErrors, "plain error")
+	assert.Equal(t, "level=warning msg=\"plain error\"\n", logbuf.String())
+
+	logbuf.Reset()
+	f.Log(ctx, log, msdsn.LogDebug, "got ERROR 9002 The transaction log is full")
+	assert.Equal(t, "", logbuf.String())
+	f.Log(ctx, log, msdsn.LogErrors, "The transaction log is full")
+	assert.Equal(t, "level=error msg=\"The transaction log is full\" sql_error_number=9002\n", logbuf.String())
+
+	logbuf.Reset()
+	f.Log(ctx, log, msdsn.LogDebug, "got ERROR 50001 not important")
+	f.Log(ctx, log, msdsn.LogErrors, "not important")
+	assert.Equal(t, "level=debug msg=\"not important\" sql_error_number=50001\n", logbuf.String())
+}
@@ -0,0 +1,151 @@
+package sqllogging
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Janitor drops ##log tables left behind in tempdb; [code].log creates
+// them for table dumps, and they are orphaned if nobody is there to dump
+// them (LogrusLogger.Querier is nil), the dump fails, or the process dies
+// mid-dump.
+type Janitor struct {
+	DB       DB
+	Logger   logrus.FieldLogger
+	TTL      time.Duration   // tables older than this are dropped; 0 means DefaultJanitorTTL
+	Interval time.Duration   // time between sweeps; 0 means DefaultJanitorInterval
+	Metrics  MetricsRegistry // if set, sweeps are counted here
+}
+
+const (
+	DefaultJanitorTTL      = time.Hour
+	DefaultJanitorInterval = 10 * time.Minute
+)
+
+// JanitorSummary is the result of a single sweep
+type JanitorSummary struct {
+	Found   int // number of ##log tables older than the TTL
+	Dropped int
+	Failed  int
+}
+
+// StartJanitor runs j in the background until ctx is done; typically with
+// the same DB as passed to With:
+//
+//	sqllogging.StartJanitor(ctx, sqllogging.Janitor{DB: dbi, Logger: logger})
+//
+// j is copied, so changing it afterwards has no effect.
+func StartJanitor(ctx context.Context, j Janitor) {
+	go j.Run(ctx)
+}
+
+// Run sweeps every Interval until ctx is done, starting immediately.
+func (j Janitor) Run(ctx context.Context) {
+	interval := j.Interval
+	if interval <= 0 {
+		interval = DefaultJanitorInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, _ = j.Sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+const sqlFindOrphanedLogTables = `
+select name from tempdb.sys.tables
+where name like '##log%' and create_date < dateadd(second, -@p1, getdate())
+order by create_date`
+
+// Sweep drops the ##log tables older than the TTL once. The age is
+// computed by SQL Server, so clock skew does not matter.
+func (j Janitor) Sweep(ctx context.Context) (summary JanitorSummary, err error) {
+	ttl := j.TTL
+	if ttl <= 0 {
+		ttl = DefaultJanitorTTL
+	}
+	var tables []string
+	rows, err := j.DB.QueryContext(ctx, sqlFindOrphanedLogTables, int64(ttl/time.Second))
+	if err == nil {
+		for rows.Next() {
+			var name string
+			if err = rows.Scan(&name); err != nil {
+				break
+			}
+			if DefaultLogTablePattern.MatchString(name) {
+				tables = append(tables, name)
+			}
+		}
+		if err == nil {
+			// an interrupted scan must not look like a clean sweep
+			err = rows.Err()
+		}
+		closeErr := rows.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		j.report(summary, err)
+		return summary, err
+	}
+
+	summary.Found = len(tables)
+	for _, table := range tables {
+		// Another janitor may have dropped it already, hence "if exists"
+		_, dropErr := j.DB.ExecContext(ctx, "drop table if exists "+sqlQuotename(table))
+		if dropErr != nil {
+			summary.Failed++
+			j.logger().WithField("table", table).Warning("Unable to drop orphaned ##log table: " + dropErr.Error())
+		} else {
+			summary.Dropped++
+		}
+	}
+	j.report(summary, nil)
+	return summary, nil
+}
+
+func (j Janitor) logger() logrus.FieldLogger {
+	if j.Logger == nil {
+		return logrus.StandardLogger()
+	}
+	return j.Logger
+}
+
+// report logs the summary of a sweep and updates the metrics
+// sqllogging_janitor_sweeps_total, sqllogging_janitor_tables_dropped_total
+// and sqllogging_janitor_drop_errors_total
+func (j Janitor) report(summary JanitorSummary, err error) {
+	if j.Metrics != nil {
+		result := "ok"
+		if err != nil {
+			result = "error"
+		}
+		_ = j.Metrics.Add("sqllogging_janitor_sweeps_total", map[string]string{"result": result}, 1)
+		_ = j.Metrics.Add("sqllogging_janitor_tables_dropped_total", nil, float64(summary.Dropped))
+		_ = j.Metrics.Add("sqllogging_janitor_drop_errors_total", nil, float64(summary.Failed))
+	}
+
+	logger := j.logger()
+	if err != nil {
+		logger.Warning("Unable to find orphaned ##log tables: " + err.Error())
+		return
+	}
+	logger = logger.WithFields(logrus.Fields{
+		"found":   summary.Found,
+		"dropped": summary.Dropped,
+		"failed":  summary.Failed,
+	})
+	if summary.Found > 0 {
+		logger.Info("Dropped orphaned ##log tables")
+	} else {
+		logger.Debug("Dropped orphaned ##log tables")
+	}
+}
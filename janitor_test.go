@@ -0,0 +1,64 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJanitorReport(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Level = logrus.DebugLevel
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+	metrics := NewMetrics(nil)
+	j := Janitor{Logger: log, Metrics: metrics}
+
+	j.report(JanitorSummary{Found: 3, Dropped: 2, Failed: 1}, nil)
+	j.report(JanitorSummary{}, nil)
+	j.report(JanitorSummary{}, errors.New("boom"))
+	assert.Equal(t, `{"dropped":2,"failed":1,"found":3,"level":"info","msg":"Dropped orphaned ##log tables"}
+{"dropped":0,"failed":0,"found":0,"level":"debug","msg":"Dropped orphaned ##log tables"}
+{"level":"warning","msg":"Unable to find orphaned ##log tables: boom"}
+`, logbuf.String())
+
+	var out bytes.Buffer
+	require.NoError(t, metrics.WritePrometheus(&out))
+	assert.Equal(t, `# TYPE sqllogging_janitor_drop_errors_total counter
+sqllogging_janitor_drop_errors_total 1
+# TYPE sqllogging_janitor_sweeps_total counter
+sqllogging_janitor_sweeps_total{result="error"} 1
+sqllogging_janitor_sweeps_total{result="ok"} 2
+# TYPE sqllogging_janitor_tables_dropped_total counter
+sqllogging_janitor_tables_dropped_total 2
+`, out.String())
+}
+
+func TestJanitorSweep(t *testing.T) {
+	dbi := sqlOpen(t)
+	ctx := context.Background()
+
+	_, err := dbi.ExecContext(ctx, `create table ##log0123456789abcdef0123456789abcdef (x int)`)
+	require.NoError(t, err)
+	j := Janitor{DB: dbi, Logger: logrus.New(), TTL: time.Second, Metrics: NewMetrics(nil)}
+
+	summary, err := j.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, summary.Found)
+
+	time.Sleep(2 * time.Second)
+	summary, err = j.Sweep(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, summary.Dropped)
+
+	var n int
+	require.NoError(t, dbi.QueryRowContext(ctx, `select count(*) from tempdb.sys.tables where name = '##log0123456789abcdef0123456789abcdef'`).Scan(&n))
+	assert.Equal(t, 0, n)
+}
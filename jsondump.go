@@ -0,0 +1,191 @@
+package sqllogging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Table dumps may also be sent inside the log messages themselves, as JSON
+// from "for json path", instead of through a ## table. This needs no second
+// connection and leaves no global tables behind. [code].log does this when
+// called with @json = 1; the JSON is split into chunks that each fit in a
+// raiserror message:
+//
+//	info:a=1 dumpid=[<id>] dumppart=1 dumpparts=3 dumptotal=1200 dumptable=[#log1] |[{"x":1,...
+//
+// The chunk follows the "|" after the fields. [code].log only sends the
+// rows up to limit=, and the number of rows in the table as dumptotal. When all parts have arrived
+// the dump is logged like a ## table dump, with the fields of the last part.
+// The overrides limit=, format= and mode= are taken from the fields as
+// well; order= and sample= are not supported, the rows come in the order
+// of "for json".
+
+const maxPendingJSONDumps = 100
+
+var pendingJSONDumps = struct {
+	sync.Mutex
+	dumps map[string][]string // dumpid -> parts; "" for parts not yet received
+	order []string            // dumpids, oldest first
+}{dumps: make(map[string][]string)}
+
+var jsonDumpFields = []string{"dumpid", "dumppart", "dumpparts", "dumptotal", "dumptable"}
+
+func isJSONDumpChunk(fields logrus.Fields) bool {
+	_, ok := fields["dumpid"].(string)
+	return ok
+}
+
+// receiveJSONDumpChunk collects a chunk; when the last one arrives the dump
+// is returned, together with the options with overrides applied and the
+// fields of the message other than the dump* and override ones.
+func receiveJSONDumpChunk(fields logrus.Fields, text string, opts TableDumpOptions) (dump TableDump, _ TableDumpOptions, otherFields logrus.Fields, complete bool, err error) {
+	id, _ := fields["dumpid"].(string)
+	part, _ := fields["dumppart"].(int)
+	parts, _ := fields["dumpparts"].(int)
+	tablename, _ := fields["dumptable"].(string)
+	dump.Name = tablename
+	chunk, found := strings.CutPrefix(strings.TrimLeft(text, " \t\r\n"), "|")
+	if !found || part < 1 || part > parts {
+		return dump, opts, nil, false, fmt.Errorf("invalid table dump chunk %d/%d", part, parts)
+	}
+
+	pendingJSONDumps.Lock()
+	chunks, ok := pendingJSONDumps.dumps[id]
+	if !ok {
+		if len(pendingJSONDumps.order) >= maxPendingJSONDumps {
+			delete(pendingJSONDumps.dumps, pendingJSONDumps.order[0])
+			pendingJSONDumps.order = pendingJSONDumps.order[1:]
+		}
+		chunks = make([]string, parts)
+		pendingJSONDumps.dumps[id] = chunks
+		pendingJSONDumps.order = append(pendingJSONDumps.order, id)
+	}
+	if len(chunks) != parts {
+		pendingJSONDumps.Unlock()
+		return dump, opts, nil, false, fmt.Errorf("inconsistent number of parts for table dump %s", id)
+	}
+	chunks[part-1] = chunk
+	for _, c := range chunks {
+		if c == "" {
+			pendingJSONDumps.Unlock()
+			return dump, opts, nil, false, nil
+		}
+	}
+	delete(pendingJSONDumps.dumps, id)
+	for i, pendingID := range pendingJSONDumps.order {
+		if pendingID == id {
+			pendingJSONDumps.order = append(pendingJSONDumps.order[:i], pendingJSONDumps.order[i+1:]...)
+			break
+		}
+	}
+	pendingJSONDumps.Unlock()
+
+	otherFields = make(logrus.Fields, len(fields))
+	for k, v := range fields {
+		otherFields[k] = v
+	}
+	for _, k := range jsonDumpFields {
+		delete(otherFields, k)
+	}
+	for _, k := range tableDumpOptionFields {
+		delete(otherFields, k)
+	}
+	opts = opts.withOverrides(fields)
+	if len(otherFields) == 0 {
+		otherFields = nil
+	}
+
+	dump, err = decodeJSONDump(tablename, strings.Join(chunks, ""))
+	if err != nil {
+		return dump, opts, otherFields, false, err
+	}
+	if limit := opts.limit(); len(dump.Rows) > limit {
+		dump.Rows = dump.Rows[:limit]
+	}
+	if total, ok := fields["dumptotal"].(int); ok && int64(total) > dump.RowsTotal {
+		dump.RowsTotal = int64(total)
+	}
+	dump.RowsOmitted = dump.RowsTotal - int64(len(dump.Rows))
+	for _, row := range dump.Rows {
+		opts.redaction.redactRow(dump.Columns, row)
+	}
+	otherFields = opts.redaction.redactFields(otherFields)
+	return dump, opts, otherFields, true, nil
+}
+
+// decodeJSONDump decodes an array of objects, keeping the order of the
+// columns as they appear in the JSON
+func decodeJSONDump(tablename string, data string) (dump TableDump, err error) {
+	dump.Name = tablename
+	dec := json.NewDecoder(strings.NewReader(data))
+	dec.UseNumber()
+	if err = expectDelim(dec, '['); err != nil {
+		return
+	}
+	columnIndex := make(map[string]int)
+	var objects []map[string]any
+	for dec.More() {
+		if err = expectDelim(dec, '{'); err != nil {
+			return
+		}
+		object := make(map[string]any)
+		for dec.More() {
+			var key string
+			var value any
+			if err = dec.Decode(&key); err != nil {
+				return
+			}
+			if err = dec.Decode(&value); err != nil {
+				return
+			}
+			if _, ok := columnIndex[key]; !ok {
+				columnIndex[key] = len(dump.Columns)
+				dump.Columns = append(dump.Columns, key)
+			}
+			object[key] = convertJSONValue(value)
+		}
+		if err = expectDelim(dec, '}'); err != nil {
+			return
+		}
+		objects = append(objects, object)
+	}
+	if err = expectDelim(dec, ']'); err != nil {
+		return
+	}
+	for _, object := range objects {
+		row := make(Row, len(dump.Columns))
+		for k, v := range object {
+			row[columnIndex[k]] = v
+		}
+		dump.Rows = append(dump.Rows, row)
+	}
+	dump.RowsTotal = int64(len(dump.Rows))
+	return dump, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v in table dump, got %v", delim, token)
+	}
+	return nil
+}
+
+// convertJSONValue turns integers into int, like scanRowsOfAny does; other
+// numbers are kept as the exact json.Number
+func convertJSONValue(value any) any {
+	if n, ok := value.(json.Number); ok {
+		if i, err := strconv.Atoi(string(n)); err == nil {
+			return i
+		}
+	}
+	return value
+}
@@ -0,0 +1,79 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeJSONDump(t *testing.T) {
+	dump, err := decodeJSONDump("#log1", `[{"b":1,"a":"x","c":1.5},{"b":2,"a":null,"c":2,"d":true}]`)
+	require.NoError(t, err)
+	assert.Equal(t, TableDump{
+		Name:    "#log1",
+		Columns: []string{"b", "a", "c", "d"},
+		Rows: []Row{
+			{1, "x", json.Number("1.5"), nil},
+			{2, nil, 2, true},
+		},
+		RowsTotal: 2,
+	}, dump)
+
+	_, err = decodeJSONDump("#log1", `{"a":1}`)
+	assert.Error(t, err)
+}
+
+func TestJSONDumpChunks(t *testing.T) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.SetOutput(&buf)
+	log.SetFormatter(&logrus.JSONFormatter{DisableTimestamp: true})
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}, TableDump: TableDumpOptions{Limit: 2}}
+
+	data := `[{"x":1,"y":"a|b"},{"x":2,"y":"50%"},{"x":3,"y":"c"}]`
+	chunks := []string{data[:20], data[20:40], data[40:]}
+	// parts may arrive out of order
+	for _, i := range []int{1, 0, 2} {
+		msg := "info:batch=7 dumpid=[abc] dumppart=" + string(rune('1'+i)) + " dumpparts=3 dumptable=[#log1]"
+		if i == 2 {
+			msg += " limit=3"
+		}
+		logger.Log(context.Background(), msdsn.LogMessages, msg+" |"+chunks[i])
+		if i != 2 {
+			assert.Equal(t, "", buf.String())
+		}
+	}
+	assert.Equal(t, strings.Join([]string{
+		`{"batch":7,"level":"info","msg":"","x":1,"y":"a|b"}`,
+		`{"batch":7,"level":"info","msg":"","x":2,"y":"50%"}`,
+		`{"batch":7,"level":"info","msg":"","x":3,"y":"c"}`,
+		``,
+	}, "\n"), buf.String())
+
+	buf.Reset()
+	logger.Log(context.Background(), msdsn.LogMessages, "info:dumpid=[def] dumppart=1 dumpparts=1 dumptable=[#log1] |"+data)
+	assert.Equal(t, strings.Join([]string{
+		`{"level":"info","msg":"","x":1,"y":"a|b"}`,
+		`{"level":"info","msg":"","x":2,"y":"50%"}`,
+		`{"level":"info","msg":"","rows_omitted":1,"rows_total":3}`,
+		``,
+	}, "\n"), buf.String())
+
+	// The rows left out by [code].log are counted as omitted too
+	buf.Reset()
+	logger.Log(context.Background(), msdsn.LogMessages, "info:dumpid=[ghi] dumppart=1 dumpparts=1 dumptotal=1200 dumptable=[#log1] |"+data)
+	assert.Equal(t, strings.Join([]string{
+		`{"level":"info","msg":"","x":1,"y":"a|b"}`,
+		`{"level":"info","msg":"","x":2,"y":"50%"}`,
+		`{"level":"info","msg":"","rows_omitted":1198,"rows_total":1200}`,
+		``,
+	}, "\n"), buf.String())
+	assert.Empty(t, pendingJSONDumps.dumps)
+}
@@ -3,13 +3,13 @@ package sqllogging
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"github.com/denisenkom/go-mssqldb/msdsn"
 	"github.com/sirupsen/logrus"
 	"io"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 )
 
 func logAtLevel(logger logrus.FieldLogger, level logrus.Level, msg string) {
@@ -82,6 +82,9 @@ func With(ctx context.Context, logger logrus.FieldLogger, dbi DB, fallback ...Lo
 		Querier:  dbi,
 		Fallback: f,
 		Stderr:   os.Stderr,
+		Sticky:   &StickyFields{},
+		Scopes:   NewScopeStack(),
+		Progress: &ProgressReporter{LogInterval: DefaultProgressLogInterval},
 	})
 }
 
@@ -89,74 +92,131 @@ func With(ctx context.Context, logger logrus.FieldLogger, dbi DB, fallback ...Lo
 // SQL log string and turns it into a nice logrus log; see README.md
 // for further description.
 type LogrusLogger struct {
-	Logger   logrus.FieldLogger // Normal logrus output
-	Querier  QuerierExecer      // For ##log-table dumping, this is used to fetch table data
-	Fallback LogrusMssqlLogger  // If `<level>:` prefix is not present, forward to this logger
-	Stderr   io.Writer          // The special "stderr:" level is written here
+	Logger    logrus.FieldLogger // Normal logrus output
+	Querier   QuerierExecer      // For ##log-table dumping, this is used to fetch table data
+	Fallback  LogrusMssqlLogger  // If `<level>:` prefix is not present, forward to this logger
+	Stderr    io.Writer          // The special "stderr:" level is written here
+	Sticky    *StickyFields      // Fields set with "fields:set"; if nil, "fields:" messages are ignored
+	Scopes    *ScopeStack        // Scopes pushed with "scope:push"; if nil, "scope:" messages are ignored
+	TableDump TableDumpOptions   // Which rows to dump from ##log-tables
+	// Which ##log-tables may be dumped and dropped
+	TableDumpPolicy TableDumpPolicy
+	// If set, table dumps run on the worker instead of in the driver's callback
+	DumpWorker *DumpWorker
+	// If set, values are redacted from fields, message texts and table dumps
+	Redaction *RedactionPolicy
+	// If set, log lines are also recorded as span events; see WithSpanEvents
+	SpanEvents SpanEventRecorder
+	// Receives "progress:" messages unless a reporter is attached with
+	// WithProgress; if nil, progress is logged every DefaultProgressLogInterval
+	Progress *ProgressReporter
+}
+
+// tableDumpOptions returns TableDump with the redaction policy attached
+func (l LogrusLogger) tableDumpOptions() TableDumpOptions {
+	opts := l.TableDump
+	opts.redaction = l.Redaction
+	return opts
 }
 
 // For simplicty, only support a very restricted set of names for log tables..
 var logTableNameRegexp = regexp.MustCompile(`^##[a-z0-9A-Z_]+$`)
 
 func (l LogrusLogger) Log(ctx context.Context, category msdsn.Log, msg string) {
-
-	if category&msdsn.LogMessages != 0 && strings.HasPrefix(msg, "Error: 50000") &&
-		strings.Contains(msg, "The error is printed in terse mode because there was error during formatting") {
-		// hack to help a common usage error..with bad error message from mssql...
-		msg = "error:Wrong format string provided to formatmessage()"
+	if l.DumpWorker == nil {
+		l.log(ctx, category, msg)
+		return
 	}
+	l.DumpWorker.submit(ctx, l, l.isTableDump(msg), func(ctx context.Context, l LogrusLogger) {
+		l.log(ctx, category, msg)
+	})
+}
 
-	logger := l.Logger
-	level, logmsg, found := strings.Cut(msg, ":")
-	if !found {
-		level = ""
-		logmsg = msg
+// isTableDump tells whether msg asks for a table dump, which is handled on
+// the DumpWorker if there is one
+func (l LogrusLogger) isTableDump(msg string) bool {
+	prefix, rest, found := strings.Cut(msg, ":")
+	if !found || prefixHandlerOrNil(prefix) == nil {
+		return false
+	}
+	_, text := parseFields(rest)
+	_, _, isDump := parseTableDump(text, l.TableDump)
+	if !isDump {
+		_, _, isDump = parseTableDump(rest, l.TableDump)
 	}
+	return isDump
+}
 
-	// Support only a subset of logrus.ParseLevel, and in a stricter way,
-	// + support special "" and "stderr" levels
-	switch level {
-	case "debug", "info", "warning", "error":
-		var logrusLevel logrus.Level
-		switch level {
-		case "debug":
-			logrusLevel = logrus.DebugLevel
-		case "info":
-			logrusLevel = logrus.InfoLevel
-		case "warning":
-			logrusLevel = logrus.WarnLevel
-		case "error":
-			logrusLevel = logrus.ErrorLevel
-		}
-		var fields logrus.Fields
-		fields, logmsg = parseFields(logmsg)
-		if fields != nil {
-			logger = logger.WithFields(fields)
-		}
+// log handles msg; the sticky fields and scopes are applied here, so that
+// messages queued on the DumpWorker see the changes made by the ones
+// before them
+func (l LogrusLogger) log(ctx context.Context, category msdsn.Log, msg string) {
+	logger, scopePrefix := l.Scopes.apply(l.Sticky.apply(l.Logger))
 
-		if l.Querier != nil && logTableNameRegexp.MatchString(logmsg) {
-			tableDumpStructured(ctx, logger, logrusLevel, l.Querier, logmsg)
-			dropTable(ctx, l.Querier, logmsg)
-		} else {
-			logAtLevel(logger, logrusLevel, logmsg)
+	if tracker := transactionTrackerOrNil(ctx); tracker != nil {
+		if category == msdsn.LogTransaction {
+			tracker.log(logger, msg)
+			return
 		}
-	case "stderr":
-		if l.Querier != nil && logTableNameRegexp.MatchString(logmsg) {
-			tableDumpPrettyPrint(ctx, l.Stderr, l.Querier, logmsg)
-			dropTable(ctx, l.Querier, logmsg)
-		} else {
-			_, _ = fmt.Fprintln(l.Stderr, logmsg)
+		logger = tracker.withFields(logger)
+	}
+
+	if category == msdsn.LogRows || category == msdsn.LogRetries {
+		if stats := StatsOrNil(ctx); stats != nil {
+			stats.log(category, msg)
+			return
 		}
-	default:
-		if level != "" {
-			logmsg = level + ":" + logmsg
+	}
+
+	if category == msdsn.LogSQL || category == msdsn.LogParams {
+		if tracer := queryTracerOrNil(ctx); tracer != nil {
+			tracer.log(logger, category, msg, l.Redaction)
+			return
 		}
-		l.Fallback.Log(ctx, logger, category, msg)
 	}
+
+	msg, diagnosticFields := diagnose(category, msg)
+	if diagnosticFields != nil {
+		logger = logger.WithFields(diagnosticFields)
+	}
+
+	prefix, rest, found := strings.Cut(msg, ":")
+	var handler PrefixHandler
+	if found {
+		handler = prefixHandlerOrNil(prefix)
+	}
+	if handler == nil {
+		l.Fallback.Log(ctx, logger, category, l.Redaction.redactText(msg))
+		return
+	}
+
+	fields, text := parseFields(rest)
+	mapReservedFields(fields, time.Now())
+	m := Message{
+		Category: category,
+		Prefix:   prefix,
+		Raw:      rest,
+		Fields:   fields,
+		Text:     text,
+		Logger:   logger,
+		Scope:    scopePrefix,
+
+		raw:       rest,
+		text:      text,
+		redaction: l.Redaction,
+	}
+	if l.Redaction != nil && !isJSONDumpChunk(fields) {
+		// Only after parsing, as the mask may contain brackets; JSON dumps
+		// are redacted after they are decoded
+		m.Fields = l.Redaction.redactFields(fields)
+		m.Raw = l.Redaction.redactRaw(rest)
+		m.Text = l.Redaction.redactText(text)
+	}
+	handler(ctx, l, m)
 }
 
 func dropTable(ctx context.Context, querier QuerierExecer, tablename string) {
-	_, _ = querier.ExecContext(ctx, "drop table "+sqlQuotename(tablename))
+	_, _ = querier.ExecContext(withoutSqllogging(ctx), "drop table "+sqlQuotename(tablename))
 }
 
 func sqlQuotename(name string) string {
@@ -0,0 +1,270 @@
+package sqllogging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MetricsRegistry receives metrics sent from SQL with the "metric:" prefix:
+//
+//	metric:name=[orders_processed] type=[counter] value=17 shard=[3]
+//
+// type is one of counter, gauge or histogram; all other fields are labels.
+// Integer values may be given as-is; other numbers must be quoted, e.g.
+// value=[1.5].
+type MetricsRegistry interface {
+	Add(name string, labels map[string]string, delta float64) error
+	Set(name string, labels map[string]string, value float64) error
+	Observe(name string, labels map[string]string, value float64) error
+}
+
+// DefaultBuckets are suitable histogram buckets for durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricHandler returns a PrefixHandler that updates registry. The "metric:"
+// channel is not handled unless registered, e.g.
+//
+//	sqllogging.RegisterPrefix("metric", sqllogging.MetricHandler(registry))
+func MetricHandler(registry MetricsRegistry) PrefixHandler {
+	return func(ctx context.Context, l LogrusLogger, m Message) {
+		err := updateMetric(registry, m)
+		if err != nil {
+			m.Logger.WithFields(m.Fields).Warning("Invalid metric from SQL: " + err.Error())
+		}
+	}
+}
+
+func updateMetric(registry MetricsRegistry, m Message) error {
+	var name, typ string
+	var value float64
+	var hasValue bool
+	labels := make(map[string]string)
+	for k, v := range m.Fields {
+		switch k {
+		case "name":
+			name = fmt.Sprint(v)
+		case "type":
+			typ = fmt.Sprint(v)
+		case "value":
+			switch v := v.(type) {
+			case int:
+				value, hasValue = float64(v), true
+			case string:
+				f, err := strconv.ParseFloat(v, 64)
+				if err != nil {
+					return fmt.Errorf("value is not a number: %q", v)
+				}
+				value, hasValue = f, true
+			}
+		default:
+			if v == nil {
+				labels[k] = ""
+			} else {
+				labels[k] = fmt.Sprint(v)
+			}
+		}
+	}
+	if !hasValue {
+		return fmt.Errorf("missing value")
+	}
+	switch typ {
+	case "counter":
+		return registry.Add(name, labels, value)
+	case "gauge":
+		return registry.Set(name, labels, value)
+	case "histogram":
+		return registry.Observe(name, labels, value)
+	default:
+		return fmt.Errorf("unknown metric type %q", typ)
+	}
+}
+
+// Metrics is a simple in-process MetricsRegistry that can be exposed in the
+// Prometheus text format with WritePrometheus.
+type Metrics struct {
+	buckets []float64
+
+	mu       sync.Mutex
+	families map[string]*metricFamily
+}
+
+type metricFamily struct {
+	typ    string
+	series map[string]*metricSeries // by formatted labels
+}
+
+type metricSeries struct {
+	labels  string // formatted as `a="1",b="2"`
+	value   float64
+	buckets []uint64 // histograms only; cumulative counts per bucket
+	count   uint64
+}
+
+func NewMetrics(buckets []float64) *Metrics {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Metrics{
+		buckets:  buckets,
+		families: make(map[string]*metricFamily),
+	}
+}
+
+// Handler returns a PrefixHandler that updates r; see MetricHandler.
+func (r *Metrics) Handler() PrefixHandler {
+	return MetricHandler(r)
+}
+
+var (
+	metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+func (r *Metrics) Add(name string, labels map[string]string, delta float64) error {
+	if delta < 0 {
+		return fmt.Errorf("counter %s cannot decrease", name)
+	}
+	return r.update(name, "counter", labels, func(s *metricSeries) {
+		s.value += delta
+	})
+}
+
+func (r *Metrics) Set(name string, labels map[string]string, value float64) error {
+	return r.update(name, "gauge", labels, func(s *metricSeries) {
+		s.value = value
+	})
+}
+
+func (r *Metrics) Observe(name string, labels map[string]string, value float64) error {
+	return r.update(name, "histogram", labels, func(s *metricSeries) {
+		if s.buckets == nil {
+			s.buckets = make([]uint64, len(r.buckets))
+		}
+		for i, upper := range r.buckets {
+			if value <= upper {
+				s.buckets[i]++
+			}
+		}
+		s.value += value
+		s.count++
+	})
+}
+
+func (r *Metrics) update(name, typ string, labels map[string]string, f func(*metricSeries)) error {
+	if !metricNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid metric name %q", name)
+	}
+	for k := range labels {
+		// names starting with __ are reserved, and le is used for buckets
+		if !labelNameRegexp.MatchString(k) || strings.HasPrefix(k, "__") || (typ == "histogram" && k == "le") {
+			return fmt.Errorf("invalid label name %q", k)
+		}
+	}
+	formatted := formatMetricLabels(labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	family, ok := r.families[name]
+	if !ok {
+		family = &metricFamily{typ: typ, series: make(map[string]*metricSeries)}
+		r.families[name] = family
+	} else if family.typ != typ {
+		return fmt.Errorf("metric %s is a %s, not a %s", name, family.typ, typ)
+	}
+	series, ok := family.series[formatted]
+	if !ok {
+		series = &metricSeries{labels: formatted}
+		family.series[formatted] = series
+	}
+	f(series)
+	return nil
+}
+
+func formatMetricLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[k]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// WritePrometheus writes all metrics in the Prometheus text exposition format.
+func (r *Metrics) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		family := r.families[name]
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, family.typ)
+
+		keys := make([]string, 0, len(family.series))
+		for k := range family.series {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			s := family.series[k]
+			if family.typ != "histogram" {
+				fmt.Fprintf(&b, "%s%s %s\n", name, braced(s.labels), formatMetricValue(s.value))
+				continue
+			}
+			for i, upper := range r.buckets {
+				fmt.Fprintf(&b, "%s_bucket%s %d\n", name, braced(joinLabels(s.labels, `le="`+formatMetricValue(upper)+`"`)), s.buckets[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", name, braced(joinLabels(s.labels, `le="+Inf"`)), s.count)
+			fmt.Fprintf(&b, "%s_sum%s %s\n", name, braced(s.labels), formatMetricValue(s.value))
+			fmt.Fprintf(&b, "%s_count%s %d\n", name, braced(s.labels), s.count)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,60 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	metrics := NewMetrics([]float64{1, 10})
+	ctx := context.Background()
+	handler := MetricHandler(metrics)
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}}
+	send := func(raw string) {
+		fields, text := parseFields(raw)
+		handler(ctx, logger, Message{Category: msdsn.LogMessages, Prefix: "metric", Fields: fields, Text: text, Logger: log})
+	}
+
+	send("name=[orders_processed] type=[counter] value=17 shard=[3]")
+	send("name=[orders_processed] type=[counter] value=3 shard=[3]")
+	send("name=[orders_processed] type=[counter] value=1 shard=[4]")
+	send("name=[queue_length] type=[gauge] value=5")
+	send("name=[queue_length] type=[gauge] value=[2.5]")
+	send("name=[batch_seconds] type=[histogram] value=[0.5]")
+	send("name=[batch_seconds] type=[histogram] value=5")
+	send("name=[batch_seconds] type=[histogram] value=50")
+	assert.Equal(t, "", logbuf.String())
+
+	send("name=[queue_length] type=[counter] value=1")
+	assert.Equal(t, `{"level":"warning","msg":"Invalid metric from SQL: metric queue_length is a gauge, not a counter","name":"queue_length","type":"counter","value":1}`+"\n", logbuf.String())
+
+	assert.EqualError(t, metrics.Add("x", map[string]string{"a-b": "1"}, 1), `invalid label name "a-b"`)
+	assert.EqualError(t, metrics.Observe("batch_seconds", map[string]string{"le": "1"}, 1), `invalid label name "le"`)
+	assert.EqualError(t, metrics.Set("1x", nil, 1), `invalid metric name "1x"`)
+
+	var out bytes.Buffer
+	require.NoError(t, metrics.WritePrometheus(&out))
+	assert.Equal(t, `# TYPE batch_seconds histogram
+batch_seconds_bucket{le="1"} 1
+batch_seconds_bucket{le="10"} 2
+batch_seconds_bucket{le="+Inf"} 3
+batch_seconds_sum 55.5
+batch_seconds_count 3
+# TYPE orders_processed counter
+orders_processed{shard="3"} 20
+orders_processed{shard="4"} 1
+# TYPE queue_length gauge
+queue_length 2.5
+`, out.String())
+}
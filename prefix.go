@@ -0,0 +1,144 @@
+package sqllogging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+)
+
+// Message is a log message from SQL with a registered "prefix:", as passed
+// to a PrefixHandler. Raw, Fields and Text are redacted if
+// LogrusLogger.Redaction is set.
+type Message struct {
+	Category msdsn.Log
+	Prefix   string
+	Raw      string             // everything after "prefix:"
+	Fields   logrus.Fields      // fields parsed from the start of Raw; nil if none
+	Text     string             // the rest of Raw after the fields
+	Logger   logrus.FieldLogger // the logger for the call; Fields are not attached
+	Scope    string             // prefix for Text from open scopes, e.g. "step1/step2: "; see ScopeStack
+
+	// Raw and Text before redaction, which only applies to what is logged;
+	// parse these instead
+	raw, text string
+	redaction *RedactionPolicy
+}
+
+// PrefixHandler handles the messages of a given prefix. l is the LogrusLogger
+// that received the message, for access to Querier, Stderr and so on.
+type PrefixHandler func(ctx context.Context, l LogrusLogger, m Message)
+
+var (
+	prefixHandlersMu sync.RWMutex
+	prefixHandlers   = map[string]PrefixHandler{
+		"debug":   levelHandler(logrus.DebugLevel),
+		"info":    levelHandler(logrus.InfoLevel),
+		"warning": levelHandler(logrus.WarnLevel),
+		"error":   levelHandler(logrus.ErrorLevel),
+		"stderr":  stderrHandler,
+	}
+)
+
+// RegisterPrefix makes LogrusLogger send messages starting with "prefix:"
+// to handler instead of the fallback. This may be used to add new channels
+// from SQL, or to replace the built-in levels.
+func RegisterPrefix(prefix string, handler PrefixHandler) {
+	if prefix == "" || strings.Contains(prefix, ":") {
+		panic("invalid prefix: " + prefix)
+	}
+	prefixHandlersMu.Lock()
+	defer prefixHandlersMu.Unlock()
+	prefixHandlers[prefix] = handler
+}
+
+func prefixHandlerOrNil(prefix string) PrefixHandler {
+	prefixHandlersMu.RLock()
+	defer prefixHandlersMu.RUnlock()
+	return prefixHandlers[prefix]
+}
+
+// parseAction parses control messages such as "set a=1", where the action
+// comes before the fields, or "a=1 set", as [code].log puts it.
+func parseAction(m Message, actions ...string) (action string, fields logrus.Fields) {
+	action, rest, _ := strings.Cut(strings.TrimSpace(m.raw), " ")
+	for _, a := range actions {
+		if action == a {
+			fields, _ = parseFields(rest)
+			return action, m.redaction.redactFields(fields)
+		}
+	}
+	return strings.TrimSpace(m.Text), m.Fields
+}
+
+func levelHandler(level logrus.Level) PrefixHandler {
+	return func(ctx context.Context, l LogrusLogger, m Message) {
+		if isJSONDumpChunk(m.Fields) {
+			dump, opts, fields, complete, err := receiveJSONDumpChunk(m.Fields, m.Text, l.tableDumpOptions())
+			if err != nil {
+				m.Logger.Warning("Unable to log table " + dump.Name + ": " + err.Error())
+			} else if complete {
+				logTableDump(m.Logger.WithFields(fields), level, dump, opts)
+			}
+			return
+		}
+		logger := m.Logger
+		if m.Fields != nil {
+			logger = logger.WithFields(m.Fields)
+		}
+		if tablename, opts, ok := parseTableDump(m.text, l.tableDumpOptions()); ok && l.Querier != nil {
+			for _, table := range opts.tables(tablename) {
+				if err := l.TableDumpPolicy.verify(ctx, l.Querier, table, opts.nonce); err != nil {
+					logger.Warning("Refusing to log table " + table + ": " + err.Error())
+					return
+				}
+			}
+			if opts.diffWith != "" {
+				tableDiffStructured(ctx, logger, level, l.Querier, tablename, opts)
+			} else {
+				tableDumpStructured(ctx, logger, level, l.Querier, tablename, opts)
+			}
+			for _, table := range opts.tables(tablename) {
+				l.TableDumpPolicy.drop(ctx, l.Querier, table)
+			}
+		} else {
+			logAtLevel(logger, level, m.Scope+m.Text)
+			recordSpanEvent(ctx, l, level, m)
+		}
+	}
+}
+
+// stderrHandler writes the message as-is, without parsing fields (except
+// for table dumps)
+func stderrHandler(ctx context.Context, l LogrusLogger, m Message) {
+	if isJSONDumpChunk(m.Fields) {
+		dump, opts, _, complete, err := receiveJSONDumpChunk(m.Fields, m.Text, l.tableDumpOptions())
+		if err != nil {
+			_, _ = fmt.Fprintln(l.Stderr, dump.Name+": "+err.Error())
+		} else if complete {
+			renderTableDump(l.Stderr, dump, opts)
+		}
+		return
+	}
+	if tablename, opts, ok := parseTableDump(m.raw, l.tableDumpOptions()); ok && l.Querier != nil {
+		for _, table := range opts.tables(tablename) {
+			if err := l.TableDumpPolicy.verify(ctx, l.Querier, table, opts.nonce); err != nil {
+				_, _ = fmt.Fprintln(l.Stderr, table+": refusing to dump table: "+err.Error())
+				return
+			}
+		}
+		if opts.diffWith != "" {
+			tableDiffPrettyPrint(ctx, l.Stderr, l.Querier, tablename, opts)
+		} else {
+			tableDumpPrettyPrint(ctx, l.Stderr, l.Querier, tablename, opts)
+		}
+		for _, table := range opts.tables(tablename) {
+			l.TableDumpPolicy.drop(ctx, l.Querier, table)
+		}
+	} else {
+		_, _ = fmt.Fprintln(l.Stderr, m.Raw)
+	}
+}
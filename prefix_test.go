@@ -0,0 +1,65 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// restorePrefixHandlers restores the registry of prefixes after the test
+func restorePrefixHandlers(t *testing.T) {
+	prefixHandlersMu.RLock()
+	saved := make(map[string]PrefixHandler, len(prefixHandlers))
+	for prefix, handler := range prefixHandlers {
+		saved[prefix] = handler
+	}
+	prefixHandlersMu.RUnlock()
+	t.Cleanup(func() {
+		prefixHandlersMu.Lock()
+		defer prefixHandlersMu.Unlock()
+		prefixHandlers = saved
+	})
+}
+
+func TestRegisterPrefix(t *testing.T) {
+	var logbuf, stderr bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	var got []Message
+	restorePrefixHandlers(t)
+	RegisterPrefix("testprefix", func(ctx context.Context, l LogrusLogger, m Message) {
+		got = append(got, m)
+	})
+
+	ctx := context.Background()
+	logger := LogrusLogger{
+		Logger:   log,
+		Fallback: StandardFallbackLogrusMssqlLogger{Mask: msdsn.LogMessages, Level: logrus.WarnLevel},
+		Stderr:   &stderr,
+	}
+	logger.Log(ctx, msdsn.LogMessages, "testprefix:a=1 b=[x] hello")
+	logger.Log(ctx, msdsn.LogMessages, "stderr:a=1 raw")
+	logger.Log(ctx, msdsn.LogMessages, "unknown:a=1 to fallback")
+
+	assert.Equal(t, []Message{{
+		Category: msdsn.LogMessages,
+		Prefix:   "testprefix",
+		Raw:      "a=1 b=[x] hello",
+		Fields:   logrus.Fields{"a": 1, "b": "x"},
+		Text:     "hello",
+		Logger:   log,
+
+		raw:  "a=1 b=[x] hello",
+		text: "hello",
+	}}, got)
+	assert.Equal(t, "a=1 raw\n", stderr.String())
+	assert.Equal(t, `{"level":"warning","msg":"unknown:a=1 to fallback"}`+"\n", logbuf.String())
+
+	assert.Panics(t, func() { RegisterPrefix("a:b", nil) })
+}
@@ -0,0 +1,92 @@
+package sqllogging
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Progress is reported from SQL with the "progress:" prefix:
+//
+//	progress:done=500 total=10000 stage=[import]
+type Progress struct {
+	Done   int
+	Total  int           // 0 if not given
+	Stage  string        // "" if not given
+	Fields logrus.Fields // all fields as parsed, including the ones above
+}
+
+// ProgressReporter receives progress from long-running procedures; attach
+// it with WithProgress.
+type ProgressReporter struct {
+	// Callback, if set, is called for every progress message.
+	Callback func(ctx context.Context, p Progress)
+	// LogInterval, if not zero, makes progress be logged at info level, at
+	// most once per interval, and always when done reaches total.
+	LogInterval time.Duration
+
+	mu         sync.Mutex
+	lastLogged time.Time
+	now        func() time.Time
+}
+
+// DefaultProgressLogInterval is the LogInterval of the reporter used when
+// none is attached to the context or set on LogrusLogger
+const DefaultProgressLogInterval = 10 * time.Second
+
+var defaultProgressReporter = &ProgressReporter{LogInterval: DefaultProgressLogInterval}
+
+// WithProgress attaches reporter to ctx; it is used by the "progress:"
+// channel instead of LogrusLogger.Progress.
+func WithProgress(ctx context.Context, reporter *ProgressReporter) context.Context {
+	return context.WithValue(ctx, ckProgress, reporter)
+}
+
+func progressReporterOrNil(ctx context.Context) *ProgressReporter {
+	val := ctx.Value(ckProgress)
+	if val == nil {
+		return nil
+	}
+	return val.(*ProgressReporter)
+}
+
+func init() {
+	RegisterPrefix("progress", progressHandler)
+}
+
+func progressHandler(ctx context.Context, l LogrusLogger, m Message) {
+	reporter := progressReporterOrNil(ctx)
+	if reporter == nil {
+		reporter = l.Progress
+	}
+	if reporter == nil {
+		reporter = defaultProgressReporter
+	}
+	p := Progress{Fields: m.Fields}
+	p.Done, _ = m.Fields["done"].(int)
+	p.Total, _ = m.Fields["total"].(int)
+	p.Stage, _ = m.Fields["stage"].(string)
+
+	if reporter.Callback != nil {
+		reporter.Callback(ctx, p)
+	}
+	if reporter.LogInterval != 0 && reporter.shouldLog(p) {
+		m.Logger.WithFields(m.Fields).Info("progress")
+	}
+}
+
+func (r *ProgressReporter) shouldLog(p Progress) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.now != nil {
+		now = r.now()
+	}
+	if p.Total != 0 && p.Done >= p.Total || now.Sub(r.lastLogged) >= r.LogInterval {
+		r.lastLogged = now
+		return true
+	}
+	return false
+}
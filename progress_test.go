@@ -0,0 +1,59 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgress(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	t0 := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := t0
+	var got []Progress
+	reporter := &ProgressReporter{
+		Callback:    func(ctx context.Context, p Progress) { got = append(got, p) },
+		LogInterval: 10 * time.Second,
+		now:         func() time.Time { return clock },
+	}
+	ctx := WithProgress(context.Background(), reporter)
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}}
+
+	logger.Log(ctx, msdsn.LogMessages, "progress:done=0 total=20 stage=[import]")
+	clock = t0.Add(5 * time.Second)
+	logger.Log(ctx, msdsn.LogMessages, "progress:done=10 total=20 stage=[import]")
+	clock = t0.Add(6 * time.Second)
+	logger.Log(ctx, msdsn.LogMessages, "progress:done=20 total=20 stage=[import]")
+	clock = t0.Add(7 * time.Second)
+	logger.Log(ctx, msdsn.LogMessages, "progress:done=5")
+
+	assert.Equal(t, 4, len(got))
+	assert.Equal(t, Progress{Done: 10, Total: 20, Stage: "import", Fields: logrus.Fields{"done": 10, "total": 20, "stage": "import"}}, got[1])
+	assert.Equal(t, Progress{Done: 5, Fields: logrus.Fields{"done": 5}}, got[3])
+	assert.Equal(t, ``+
+		`{"done":0,"level":"info","msg":"progress","stage":"import","total":20}
+{"done":20,"level":"info","msg":"progress","stage":"import","total":20}
+`, logbuf.String())
+
+	// Without a reporter in the context, progress is logged through the
+	// throttle of LogrusLogger.Progress
+	logbuf.Reset()
+	logger.Progress = &ProgressReporter{LogInterval: 10 * time.Second, now: func() time.Time { return clock }}
+	logger.Log(context.Background(), msdsn.LogMessages, "progress:done=1")
+	logger.Log(context.Background(), msdsn.LogMessages, "progress:done=2")
+	clock = t0.Add(20 * time.Second)
+	logger.Log(context.Background(), msdsn.LogMessages, "progress:done=3")
+	assert.Equal(t, ``+
+		`{"done":1,"level":"info","msg":"progress"}
+{"done":3,"level":"info","msg":"progress"}
+`, logbuf.String())
+}
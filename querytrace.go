@@ -0,0 +1,121 @@
+package sqllogging
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+)
+
+// QueryTracer turns the driver's LogSQL and LogParams output into one
+// structured "query" log entry per statement. Include msdsn.LogSQL (and
+// msdsn.LogParams for parameters) in the "log=" DSN parameter, and attach
+// the tracer with WithQueryTracer.
+//
+// The driver does not signal when a statement completes, so the entry for a
+// statement is written when the next statement starts on the same context, or
+// when Finish is called; the elapsed time is measured up to that point.
+type QueryTracer struct {
+	Level logrus.Level
+	// RedactParam, if set, is applied to every parameter value before logging.
+	RedactParam func(name, value string) string
+
+	mu      sync.Mutex
+	current *tracedQuery
+	now     func() time.Time
+}
+
+type tracedQuery struct {
+	logger  logrus.FieldLogger
+	sql     string
+	params  map[string]string
+	started time.Time
+}
+
+// NewQueryTracer returns a QueryTracer logging at debug level.
+func NewQueryTracer() *QueryTracer {
+	return &QueryTracer{Level: logrus.DebugLevel}
+}
+
+// RedactAllParams can be used as QueryTracer.RedactParam to only log
+// parameter names.
+func RedactAllParams(name, value string) string {
+	return "[redacted]"
+}
+
+// WithQueryTracer attaches tracer to ctx; it is used by LogrusLogger for the
+// LogSQL and LogParams categories.
+func WithQueryTracer(ctx context.Context, tracer *QueryTracer) context.Context {
+	return context.WithValue(ctx, ckQueryTracer, tracer)
+}
+
+func queryTracerOrNil(ctx context.Context) *QueryTracer {
+	val := ctx.Value(ckQueryTracer)
+	if val == nil {
+		return nil
+	}
+	return val.(*QueryTracer)
+}
+
+func (t *QueryTracer) timeNow() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+func (t *QueryTracer) log(logger logrus.FieldLogger, category msdsn.Log, msg string, redaction *RedactionPolicy) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.timeNow()
+	switch category {
+	case msdsn.LogSQL:
+		t.flush(now)
+		t.current = &tracedQuery{
+			logger:  logger,
+			sql:     redaction.redactText(msg),
+			started: now,
+		}
+	case msdsn.LogParams:
+		if t.current == nil {
+			return
+		}
+		// The driver logs parameters as "\t@name\tvalue"
+		name, value, _ := strings.Cut(strings.TrimPrefix(msg, "\t"), "\t")
+		value = redaction.redactString(strings.TrimPrefix(name, "@"), value)
+		if t.RedactParam != nil {
+			value = t.RedactParam(name, value)
+		}
+		if t.current.params == nil {
+			t.current.params = make(map[string]string)
+		}
+		t.current.params[name] = value
+	}
+}
+
+// Finish writes the entry for the statement in progress, if any. Call it
+// after the call using the context has completed.
+func (t *QueryTracer) Finish() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flush(t.timeNow())
+}
+
+func (t *QueryTracer) flush(now time.Time) {
+	q := t.current
+	if q == nil {
+		return
+	}
+	t.current = nil
+	fields := logrus.Fields{
+		"sql":        q.sql,
+		"elapsed_ms": float64(now.Sub(q.started).Microseconds()) / 1000,
+	}
+	if q.params != nil {
+		fields["params"] = q.params
+	}
+	logAtLevel(q.logger.WithFields(fields), t.Level, "query")
+}
@@ -0,0 +1,86 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryTracer(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Level = logrus.DebugLevel
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	t0 := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := t0
+	tracer := NewQueryTracer()
+	tracer.now = func() time.Time { return clock }
+	tracer.RedactParam = func(name, value string) string {
+		if name == "@secret" {
+			return RedactAllParams(name, value)
+		}
+		return value
+	}
+
+	ctx := WithQueryTracer(context.Background(), tracer)
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}}
+
+	logger.Log(ctx, msdsn.LogSQL, "select @p1, @secret")
+	logger.Log(ctx, msdsn.LogParams, "\t@p1\t42")
+	logger.Log(ctx, msdsn.LogParams, "\t@secret\thunter2")
+	assert.Equal(t, "", logbuf.String())
+
+	clock = t0.Add(1500 * time.Microsecond)
+	logger.Log(ctx, msdsn.LogSQL, "select 1")
+	assert.Equal(t,
+		`{"elapsed_ms":1.5,"level":"debug","msg":"query","params":{"@p1":"42","@secret":"[redacted]"},"sql":"select @p1, @secret"}`+"\n",
+		logbuf.String())
+
+	logbuf.Reset()
+	clock = t0.Add(2 * time.Second)
+	tracer.Finish()
+	assert.Equal(t, `{"elapsed_ms":1998.5,"level":"debug","msg":"query","sql":"select 1"}`+"\n", logbuf.String())
+
+	logbuf.Reset()
+	tracer.Finish()
+	assert.Equal(t, "", logbuf.String())
+}
+
+func TestQueryTracerIgnoreTableDumps(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Level = logrus.DebugLevel
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	t0 := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := t0
+	tracer := NewQueryTracer()
+	tracer.now = func() time.Time { return clock }
+	logger := LogrusLogger{
+		Logger:          log,
+		Fallback:        StandardFallbackLogrusMssqlLogger{},
+		Querier:         loggingQuerier{},
+		TableDumpPolicy: TableDumpPolicy{Pattern: regexp.MustCompile(`^##dump`), SkipVerification: true},
+	}
+	ctx := WithLogger(WithQueryTracer(context.Background(), tracer), logger)
+
+	// The queries made to dump the table neither end the statement nor
+	// show up as queries
+	logger.Log(ctx, msdsn.LogSQL, "exec dbo.load")
+	clock = t0.Add(time.Millisecond)
+	logger.Log(ctx, msdsn.LogMessages, "info:##dump1")
+	clock = t0.Add(2 * time.Millisecond)
+	tracer.Finish()
+	assert.Equal(t, `{"level":"warning","msg":"Unable to log table ##dump1: boom"}
+{"elapsed_ms":2,"level":"debug","msg":"query","sql":"exec dbo.load"}
+`, logbuf.String())
+}
@@ -0,0 +1,182 @@
+package sqllogging
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RedactionPolicy masks or hashes values from SQL before they are logged:
+// the fields of log messages, the columns of table dumps, and the parts of
+// the message text matching Values. Set it as LogrusLogger.Redaction.
+type RedactionPolicy struct {
+	Keys   []*regexp.Regexp // names of fields and columns whose values are redacted
+	Values []*regexp.Regexp // the parts of values matching these are redacted, e.g. CardNumberPattern
+	// Hash replaces values with a hash keyed with HashKey instead of Mask.
+	// The same value always gives the same hash, so that rows may still be
+	// joined on redacted values. Without a HashKey, Mask is used, as short
+	// values such as card numbers are easily found from a plain hash.
+	Hash    bool
+	HashKey []byte
+	Mask    string // "" means DefaultRedactionMask
+}
+
+const DefaultRedactionMask = "[REDACTED]"
+
+var (
+	// CardNumberPattern matches payment card numbers, optionally grouped
+	// with spaces or dashes
+	CardNumberPattern = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+	// NorwegianNationalIDPattern matches 11-digit Norwegian national
+	// identity numbers
+	NorwegianNationalIDPattern = regexp.MustCompile(`\b[0-7]\d[01]\d{3} ?\d{5}\b`)
+)
+
+// Validate returns an error if the policy is misconfigured
+func (p *RedactionPolicy) Validate() error {
+	if p.Hash && len(p.HashKey) == 0 {
+		return errors.New("sqllogging: RedactionPolicy.Hash requires a HashKey")
+	}
+	return nil
+}
+
+func (p *RedactionPolicy) replacement(value string) string {
+	if p.Hash && len(p.HashKey) > 0 {
+		h := hmac.New(sha256.New, p.HashKey)
+		h.Write([]byte(value))
+		return "hash:" + hex.EncodeToString(h.Sum(nil))[:16]
+	}
+	if p.Mask == "" {
+		return DefaultRedactionMask
+	}
+	return p.Mask
+}
+
+func (p *RedactionPolicy) redactsKey(key string) bool {
+	for _, re := range p.Keys {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactText redacts the parts of s matching Values
+func (p *RedactionPolicy) redactText(s string) string {
+	if p == nil {
+		return s
+	}
+	for _, re := range p.Values {
+		s = re.ReplaceAllStringFunc(s, p.replacement)
+	}
+	return s
+}
+
+func (p *RedactionPolicy) redactValue(key string, value any) any {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		s = v
+	case json.Number:
+		s = string(v)
+	case int, int64:
+		s = fmt.Sprint(v)
+	default:
+		if !p.redactsKey(key) {
+			return value
+		}
+		s = fmt.Sprint(v)
+	}
+	if p.redactsKey(key) {
+		return p.replacement(s)
+	}
+	if redacted := p.redactText(s); redacted != s {
+		return redacted
+	}
+	return value
+}
+
+// redactString redacts the value of a field or parameter
+func (p *RedactionPolicy) redactString(key, value string) string {
+	if p == nil {
+		return value
+	}
+	return p.redactValue(key, value).(string)
+}
+
+// redactFields redacts fields in place
+func (p *RedactionPolicy) redactFields(fields logrus.Fields) logrus.Fields {
+	if p == nil {
+		return fields
+	}
+	for k, v := range fields {
+		fields[k] = p.redactValue(k, v)
+	}
+	return fields
+}
+
+// redactRow redacts row in place
+func (p *RedactionPolicy) redactRow(columns []string, row Row) Row {
+	if p == nil {
+		return row
+	}
+	for i, value := range row {
+		row[i] = p.redactValue(columns[i], value)
+	}
+	return row
+}
+
+// redactDiff redacts the rows and values of diff in place
+func (p *RedactionPolicy) redactDiff(diff TableDiff) TableDiff {
+	if p == nil {
+		return diff
+	}
+	for _, dump := range []TableDump{diff.Added, diff.Removed} {
+		for _, row := range dump.Rows {
+			p.redactRow(dump.Columns, row)
+		}
+	}
+	for _, change := range diff.Changed {
+		p.redactRow(diff.Key, change.Key)
+		p.redactRow(change.Columns, change.Old)
+		p.redactRow(change.Columns, change.New)
+	}
+	return diff
+}
+
+// redactRaw redacts a message as written by [code].log, keeping the order
+// of the fields, for handlers that write the message as-is
+func (p *RedactionPolicy) redactRaw(raw string) string {
+	if p == nil {
+		return raw
+	}
+	var b strings.Builder
+	s := scanner{input: raw}
+	for {
+		s.skipWhitespace()
+		kv, found := parseKeyValue(&s)
+		if !found {
+			break
+		}
+		b.WriteString(kv.key + "=")
+		switch v := p.redactValue(kv.key, kv.value).(type) {
+		case nil:
+		case string:
+			b.WriteString(sqlQuotename(v))
+		default:
+			b.WriteString(fmt.Sprint(v))
+		}
+		b.WriteString(" ")
+	}
+	b.WriteString(p.redactText(raw[s.pos:]))
+	return b.String()
+}
@@ -0,0 +1,132 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactionPolicy(t *testing.T) {
+	policy := &RedactionPolicy{
+		Keys:   []*regexp.Regexp{regexp.MustCompile(`(?i)^(password|ssn)$`)},
+		Values: []*regexp.Regexp{CardNumberPattern, NorwegianNationalIDPattern},
+	}
+	assert.Equal(t, logrus.Fields{
+		"password": "[REDACTED]",
+		"SSN":      "[REDACTED]",
+		"card":     "[REDACTED]",
+		"note":     "paid with [REDACTED] by [REDACTED]",
+		"amount":   100,
+		"nil":      nil,
+	}, policy.redactFields(logrus.Fields{
+		"password": "hunter2",
+		"SSN":      12345,
+		"card":     4111111111111111,
+		"note":     "paid with 4111 1111 1111 1111 by 01019012345",
+		"amount":   100,
+		"nil":      nil,
+	}))
+
+	hashing := &RedactionPolicy{Keys: policy.Keys, Hash: true, HashKey: []byte("secret")}
+	row := hashing.redactRow([]string{"id", "ssn"}, Row{1, json.Number("01019012345")})
+	assert.Equal(t, 1, row[0])
+	assert.Regexp(t, `^hash:[0-9a-f]{16}$`, row[1])
+	// Deterministic, so that values may be joined
+	assert.Equal(t, row[1], hashing.redactValue("ssn", "01019012345"))
+	assert.NotEqual(t, row[1], hashing.redactValue("ssn", "01019012346"))
+
+	// Hashing without a key is rejected, and falls back to the mask
+	unkeyed := &RedactionPolicy{Keys: policy.Keys, Hash: true}
+	assert.Error(t, unkeyed.Validate())
+	assert.NoError(t, hashing.Validate())
+	assert.Equal(t, "[REDACTED]", unkeyed.redactValue("ssn", "01019012345"))
+
+	var nilPolicy *RedactionPolicy
+	assert.Equal(t, "4111111111111111", nilPolicy.redactText("4111111111111111"))
+}
+
+type auditRecords []AuditRecord
+
+func (r *auditRecords) WriteAudit(ctx context.Context, record AuditRecord) error {
+	*r = append(*r, record)
+	return nil
+}
+
+func TestRedactionInLog(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+	logger := LogrusLogger{
+		Logger:   log,
+		Fallback: StandardFallbackLogrusMssqlLogger{},
+		Sticky:   &StickyFields{},
+		Redaction: &RedactionPolicy{
+			Keys:   []*regexp.Regexp{regexp.MustCompile(`^customer$`)},
+			Values: []*regexp.Regexp{CardNumberPattern},
+		},
+	}
+	ctx := context.Background()
+
+	logger.Log(ctx, msdsn.LogMessages, "fields:set customer=[Ola Nordmann]")
+	logger.Log(ctx, msdsn.LogMessages, "info:card=[4111-1111-1111-1111] charged 4111111111111111")
+	logger.Log(ctx, msdsn.LogMessages, `info:a=1 dumpid=[x] dumppart=1 dumpparts=1 dumptable=[#log1] |[{"customer":"Kari","card":4111111111111111,"n":1}]`)
+	assert.Equal(t, `{"card":"[REDACTED]","customer":"[REDACTED]","level":"info","msg":"charged [REDACTED]"}
+{"a":1,"card":"[REDACTED]","customer":"[REDACTED]","level":"info","msg":"","n":1}
+`, logbuf.String())
+
+	// Control messages are parsed before redaction, so that the brackets
+	// of the mask do not run into the next field
+	logbuf.Reset()
+	logger.Sticky = &StickyFields{}
+	logger.Log(ctx, msdsn.LogMessages, "fields:set card=[4111 1111 1111 1111] tenant=[acme]")
+	logger.Log(ctx, msdsn.LogMessages, "info:hello")
+	assert.Equal(t, `{"card":"[REDACTED]","level":"info","msg":"hello","tenant":"acme"}`+"\n", logbuf.String())
+
+	// Messages to stderr are written as-is, but with the fields redacted
+	var stderr bytes.Buffer
+	logger.Stderr = &stderr
+	logger.Log(ctx, msdsn.LogMessages, "stderr:customer=[Kari] n=1 ok= card 4111111111111111")
+	assert.Equal(t, "customer=[[REDACTED]]] n=1 ok= card [REDACTED]\n", stderr.String())
+
+	// Messages to the fallback
+	logbuf.Reset()
+	logger.Sticky = nil
+	logger.Fallback = StandardFallbackLogrusMssqlLogger{Mask: msdsn.LogMessages, Level: logrus.InfoLevel}
+	logger.Log(ctx, msdsn.LogMessages, "paid with 4111111111111111")
+	assert.Equal(t, `{"level":"info","msg":"paid with [REDACTED]"}`+"\n", logbuf.String())
+
+	// Queries and their parameters
+	logbuf.Reset()
+	tracer := NewQueryTracer()
+	tracer.Level = logrus.InfoLevel
+	tracerCtx := WithQueryTracer(ctx, tracer)
+	logger.Log(tracerCtx, msdsn.LogSQL, "select * from Payment where card = '4111111111111111'")
+	logger.Log(tracerCtx, msdsn.LogParams, "\t@customer\tKari")
+	tracer.Finish()
+	assert.Contains(t, logbuf.String(), `"params":{"@customer":"[REDACTED]"},"sql":"select * from Payment where card = '[REDACTED]'"`)
+
+	// Span events
+	var spans MemoryTracer
+	spanCtx, root := spans.Start(ctx, "root", nil)
+	logger.SpanEvents = &spans
+	logger.Log(spanCtx, msdsn.LogMessages, "info:customer=[Kari] card 4111111111111111")
+	assert.Equal(t, []MemorySpanEvent{{Name: "card [REDACTED]", Level: logrus.InfoLevel, Attributes: map[string]any{"customer": "[REDACTED]"}}},
+		root.(*MemorySpan).Events)
+
+	// Audit records
+	var records auditRecords
+	restorePrefixHandlers(t)
+	RegisterPrefix("audit", NewAuditChain(&records).Handler)
+	logger.Log(ctx, msdsn.LogMessages, "audit:customer=[Kari] refund to 4111111111111111")
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "refund to [REDACTED]", records[0].Message)
+		assert.Equal(t, map[string]any{"customer": "[REDACTED]"}, records[0].Fields)
+	}
+}
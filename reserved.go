@@ -0,0 +1,41 @@
+package sqllogging
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reservedFields maps the fields added automatically by [code].log to the
+// standard attribute names used in logs. (Field names from SQL can only
+// contain letters, so the names used on the SQL side are different.)
+var reservedFields = map[string]string{
+	"sqlproc":      "sql.proc",
+	"sqlnestlevel": "sql.nestlevel",
+	"sqlspid":      "sql.spid",
+	"sqltime":      "sql.time",
+}
+
+// mapReservedFields renames the reserved fields in place, and adds the
+// delivery latency as "sql.latency_ms" if "sqltime" could be parsed.
+// "sqlschema" and "sqlproc" are joined into "sql.proc".
+func mapReservedFields(fields logrus.Fields, received time.Time) {
+	if schema, ok := fields["sqlschema"].(string); ok {
+		if proc, ok := fields["sqlproc"].(string); ok {
+			fields["sqlproc"] = schema + "." + proc
+		}
+		delete(fields, "sqlschema")
+	}
+	for from, to := range reservedFields {
+		if v, ok := fields[from]; ok {
+			delete(fields, from)
+			fields[to] = v
+		}
+	}
+	if s, ok := fields["sql.time"].(string); ok {
+		// sysdatetimeoffset() converted with style 127
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			fields["sql.latency_ms"] = float64(received.Sub(t).Microseconds()) / 1000
+		}
+	}
+}
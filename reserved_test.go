@@ -0,0 +1,28 @@
+package sqllogging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMapReservedFields(t *testing.T) {
+	fields, msg := parseFields("sqlschema=[dbo] sqlproc=[myproc] sqlnestlevel=2 sqlspid=55 sqltime=[2000-01-01T01:00:00.1234567+01:00] a=1 hello")
+	assert.Equal(t, "hello", msg)
+	received := time.Date(2000, 1, 1, 0, 0, 0, 223456700, time.UTC)
+	mapReservedFields(fields, received)
+	assert.Equal(t, logrus.Fields{
+		"sql.proc":       "dbo.myproc",
+		"sql.nestlevel":  2,
+		"sql.spid":       55,
+		"sql.time":       "2000-01-01T01:00:00.1234567+01:00",
+		"sql.latency_ms": 100.0,
+		"a":              1,
+	}, fields)
+
+	fields, _ = parseFields("sqltime=[garbage]")
+	mapReservedFields(fields, received)
+	assert.Equal(t, logrus.Fields{"sql.time": "garbage"}, fields)
+}
@@ -0,0 +1,142 @@
+package sqllogging
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ScopeStack holds nested scopes pushed and popped from SQL, e.g. one per
+// procedure call:
+//
+//	scope:push name=[step1] batch=42
+//	scope:pop name=[step1]
+//
+// While a scope is open, log lines of the call get its fields, the "scope"
+// field with the names of all open scopes ("step1/step2"), and the message
+// prefixed with the same. When a scope is popped its duration is logged at
+// Level. Pops that do not match the innermost scope are logged as warnings
+// with a diagnostic_code.
+type ScopeStack struct {
+	Level logrus.Level
+
+	mu     sync.Mutex
+	scopes []scope
+	now    func() time.Time
+}
+
+type scope struct {
+	name    string
+	fields  logrus.Fields
+	started time.Time
+}
+
+// NewScopeStack returns a ScopeStack logging durations at debug level.
+func NewScopeStack() *ScopeStack {
+	return &ScopeStack{Level: logrus.DebugLevel}
+}
+
+func (s *ScopeStack) timeNow() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
+
+func scopePath(scopes []scope) string {
+	names := make([]string, len(scopes))
+	for i, sc := range scopes {
+		names[i] = sc.name
+	}
+	return strings.Join(names, "/")
+}
+
+// apply adds the fields of the open scopes to logger, and returns the prefix
+// for messages
+func (s *ScopeStack) apply(logger logrus.FieldLogger) (logrus.FieldLogger, string) {
+	if s == nil {
+		return logger, ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.scopes) == 0 {
+		return logger, ""
+	}
+	fields := make(logrus.Fields)
+	for _, sc := range s.scopes {
+		for k, v := range sc.fields {
+			fields[k] = v
+		}
+	}
+	path := scopePath(s.scopes)
+	fields["scope"] = path
+	return logger.WithFields(fields), path + ": "
+}
+
+func init() {
+	RegisterPrefix("scope", scopeHandler)
+}
+
+func scopeHandler(ctx context.Context, l LogrusLogger, m Message) {
+	s := l.Scopes
+	if s == nil {
+		return
+	}
+	action, fields := parseAction(m, "push", "pop")
+	name, _ := fields["name"].(string)
+	delete(fields, "name")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.timeNow()
+	switch action {
+	case "push":
+		if name == "" {
+			name = "?"
+		}
+		s.scopes = append(s.scopes, scope{name: name, fields: fields, started: now})
+	case "pop":
+		if len(s.scopes) == 0 {
+			m.Logger.WithFields(logrus.Fields{
+				"diagnostic_code": "sql_scope_underflow",
+				"scope":           name,
+			}).Warning("scope:pop from SQL without open scope")
+			return
+		}
+		i := len(s.scopes) - 1
+		if name != "" && s.scopes[i].name != name {
+			// pop up to and including the named scope if it is open, otherwise only the innermost
+			for j := i; j >= 0; j-- {
+				if s.scopes[j].name == name {
+					i = j
+					break
+				}
+			}
+			var closed []string
+			for j := len(s.scopes) - 1; j >= i; j-- {
+				if s.scopes[j].name != name {
+					closed = append(closed, s.scopes[j].name)
+				}
+			}
+			m.Logger.WithFields(logrus.Fields{
+				"diagnostic_code":   "sql_scope_mismatch",
+				"scope":             scopePath(s.scopes),
+				"expected_scope":    name,
+				"implicitly_closed": closed,
+			}).Warning("scope:pop from SQL does not match the innermost scope")
+		}
+		for j := len(s.scopes) - 1; j >= i; j-- {
+			logger := m.Logger.WithFields(logrus.Fields{
+				"scope":       scopePath(s.scopes[:j+1]),
+				"duration_ms": float64(now.Sub(s.scopes[j].started).Microseconds()) / 1000,
+			})
+			logAtLevel(logger, s.Level, "scope "+s.scopes[j].name+" done")
+		}
+		s.scopes = s.scopes[:i]
+	default:
+		m.Logger.Warning("scope: from SQL with unknown action " + action)
+	}
+}
@@ -0,0 +1,48 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScopes(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Level = logrus.DebugLevel
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	t0 := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := t0
+	scopes := NewScopeStack()
+	scopes.now = func() time.Time { return clock }
+	ctx := context.Background()
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}, Scopes: scopes}
+
+	logger.Log(ctx, msdsn.LogMessages, "scope:push name=[outer] batch=1")
+	logger.Log(ctx, msdsn.LogMessages, "scope:name=[inner] push")
+	logger.Log(ctx, msdsn.LogMessages, "info:a=1 hello")
+	clock = t0.Add(2 * time.Millisecond)
+	logger.Log(ctx, msdsn.LogMessages, "scope:pop name=[inner]")
+	logger.Log(ctx, msdsn.LogMessages, "scope:push name=[other]")
+	clock = t0.Add(3 * time.Millisecond)
+	logger.Log(ctx, msdsn.LogMessages, "scope:pop name=[outer]")
+	logger.Log(ctx, msdsn.LogMessages, "scope:pop")
+	logger.Log(ctx, msdsn.LogMessages, "info:after")
+
+	assert.Equal(t, ``+
+		`{"a":1,"batch":1,"level":"info","msg":"outer/inner: hello","scope":"outer/inner"}
+{"batch":1,"duration_ms":2,"level":"debug","msg":"scope inner done","scope":"outer/inner"}
+{"batch":1,"diagnostic_code":"sql_scope_mismatch","expected_scope":"outer","implicitly_closed":["other"],"level":"warning","msg":"scope:pop from SQL does not match the innermost scope","scope":"outer/other"}
+{"batch":1,"duration_ms":1,"level":"debug","msg":"scope other done","scope":"outer/other"}
+{"batch":1,"duration_ms":3,"level":"debug","msg":"scope outer done","scope":"outer"}
+{"diagnostic_code":"sql_scope_underflow","level":"warning","msg":"scope:pop from SQL without open scope","scope":""}
+{"level":"info","msg":"after"}
+`, logbuf.String())
+}
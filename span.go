@@ -0,0 +1,196 @@
+package sqllogging
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Tracer creates spans for the "span:" channel. See the sqlotel package for
+// an OpenTelemetry adapter, and MemoryTracer for use in tests.
+type Tracer interface {
+	// Start starts a span as a child of the span active in ctx, and returns
+	// a context with the new span active.
+	Start(ctx context.Context, name string, attributes map[string]any) (context.Context, Span)
+}
+
+type Span interface {
+	SetAttributes(attributes map[string]any)
+	End()
+}
+
+// spanStack holds the spans started from SQL with a context
+type spanStack struct {
+	tracer Tracer
+
+	mu   sync.Mutex
+	open []openSpan
+}
+
+type openSpan struct {
+	name string
+	ctx  context.Context // context with span active, for children
+	span Span
+}
+
+// WithTracer makes the "span:" channel start spans with tracer, as children
+// of the span active in ctx:
+//
+//	span:start name=[load_batch]
+//	span:end name=[load_batch] rows=100
+//
+// Fields other than name become span attributes. The action may also come
+// after the fields, as [code].log puts it. Spans still open when the
+// call completes may be ended with EndSpans.
+func WithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, ckSpans, &spanStack{tracer: tracer})
+}
+
+func spanStackOrNil(ctx context.Context) *spanStack {
+	val := ctx.Value(ckSpans)
+	if val == nil {
+		return nil
+	}
+	return val.(*spanStack)
+}
+
+// EndSpans ends all spans started from SQL that are still open.
+func EndSpans(ctx context.Context) {
+	spans := spanStackOrNil(ctx)
+	if spans == nil {
+		return
+	}
+	spans.mu.Lock()
+	defer spans.mu.Unlock()
+	for i := len(spans.open) - 1; i >= 0; i-- {
+		spans.open[i].span.End()
+	}
+	spans.open = nil
+}
+
+func init() {
+	RegisterPrefix("span", spanHandler)
+}
+
+func spanHandler(ctx context.Context, l LogrusLogger, m Message) {
+	spans := spanStackOrNil(ctx)
+	if spans == nil {
+		return
+	}
+	action, fields := parseAction(m, "start", "end")
+	name, _ := fields["name"].(string)
+	attributes := make(map[string]any, len(fields))
+	for k, v := range fields {
+		if k != "name" {
+			attributes[k] = v
+		}
+	}
+
+	spans.mu.Lock()
+	defer spans.mu.Unlock()
+	switch action {
+	case "start":
+		if name == "" {
+			m.Logger.Warning("span:start from SQL without name")
+			return
+		}
+		parent := ctx
+		if len(spans.open) > 0 {
+			parent = spans.open[len(spans.open)-1].ctx
+		}
+		spanCtx, span := spans.tracer.Start(parent, name, attributes)
+		spans.open = append(spans.open, openSpan{name: name, ctx: spanCtx, span: span})
+	case "end":
+		i := len(spans.open) - 1
+		if name != "" {
+			for i >= 0 && spans.open[i].name != name {
+				i--
+			}
+		}
+		if i < 0 {
+			m.Logger.WithField("span", name).Warning("span:end from SQL without matching span:start")
+			return
+		}
+		for j := len(spans.open) - 1; j > i; j-- {
+			m.Logger.WithField("span", spans.open[j].name).Warning("span from SQL ended implicitly by span:end of parent")
+			spans.open[j].span.End()
+		}
+		if len(attributes) > 0 {
+			spans.open[i].span.SetAttributes(attributes)
+		}
+		spans.open[i].span.End()
+		spans.open = spans.open[:i]
+	default:
+		m.Logger.Warning("span: from SQL with unknown action " + action)
+	}
+}
+
+// MemoryTracer is a Tracer and SpanEventRecorder that records spans in
+// memory, for tests.
+type MemoryTracer struct {
+	mu    sync.Mutex
+	Spans []*MemorySpan // in order of Start
+}
+
+type MemorySpan struct {
+	Name       string
+	Parent     *MemorySpan // nil if there was no MemorySpan active in the context
+	Attributes map[string]any
+	Events     []MemorySpanEvent
+	Ended      bool
+
+	tracer *MemoryTracer
+}
+
+type MemorySpanEvent struct {
+	Name       string
+	Level      logrus.Level
+	Attributes map[string]any
+}
+
+type memorySpanKey struct{}
+
+func (t *MemoryTracer) Start(ctx context.Context, name string, attributes map[string]any) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &MemorySpan{Name: name, Attributes: make(map[string]any), tracer: t}
+	for k, v := range attributes {
+		span.Attributes[k] = v
+	}
+	span.Parent, _ = ctx.Value(memorySpanKey{}).(*MemorySpan)
+	t.Spans = append(t.Spans, span)
+	return context.WithValue(ctx, memorySpanKey{}, span), span
+}
+
+// ContextWithSpan returns a context where span is active, as if started
+// with Start.
+func (t *MemoryTracer) ContextWithSpan(ctx context.Context, span *MemorySpan) context.Context {
+	return context.WithValue(ctx, memorySpanKey{}, span)
+}
+
+// AddEvent implements SpanEventRecorder; events are added to the MemorySpan
+// active in ctx, or dropped if there is none.
+func (t *MemoryTracer) AddEvent(ctx context.Context, name string, level logrus.Level, attributes map[string]any) {
+	span, ok := ctx.Value(memorySpanKey{}).(*MemorySpan)
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span.Events = append(span.Events, MemorySpanEvent{Name: name, Level: level, Attributes: attributes})
+}
+
+func (s *MemorySpan) SetAttributes(attributes map[string]any) {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	for k, v := range attributes {
+		s.Attributes[k] = v
+	}
+}
+
+func (s *MemorySpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.Ended = true
+}
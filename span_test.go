@@ -0,0 +1,72 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpans(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	var tracer MemoryTracer
+	ctx, root := tracer.Start(context.Background(), "root", nil)
+	ctx = WithTracer(ctx, &tracer)
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}}
+
+	logger.Log(ctx, msdsn.LogMessages, "span:start name=[load_batch] batch=1")
+	logger.Log(ctx, msdsn.LogMessages, "span:name=[inner] start")
+	logger.Log(ctx, msdsn.LogMessages, "span:end name=[inner] rows=100")
+	logger.Log(ctx, msdsn.LogMessages, "span:start name=[unterminated]")
+	logger.Log(ctx, msdsn.LogMessages, "span:end name=[load_batch]")
+	logger.Log(ctx, msdsn.LogMessages, "span:end name=[load_batch]")
+	logger.Log(ctx, msdsn.LogMessages, "span:start name=[open]")
+	EndSpans(ctx)
+
+	require.Equal(t, 5, len(tracer.Spans))
+	loadBatch, inner, unterminated, open := tracer.Spans[1], tracer.Spans[2], tracer.Spans[3], tracer.Spans[4]
+	assert.False(t, root.(*MemorySpan).Ended)
+	assert.Equal(t, &MemorySpan{Name: "load_batch", Parent: root.(*MemorySpan), Attributes: map[string]any{"batch": 1}, Ended: true, tracer: &tracer}, loadBatch)
+	assert.Equal(t, &MemorySpan{Name: "inner", Parent: loadBatch, Attributes: map[string]any{"rows": 100}, Ended: true, tracer: &tracer}, inner)
+	assert.Equal(t, loadBatch, unterminated.Parent)
+	assert.True(t, unterminated.Ended)
+	assert.Equal(t, root, open.Parent)
+	assert.True(t, open.Ended)
+
+	assert.Equal(t, ``+
+		`{"level":"warning","msg":"span from SQL ended implicitly by span:end of parent","span":"unterminated"}
+{"level":"warning","msg":"span:end from SQL without matching span:start","span":"load_batch"}
+`, logbuf.String())
+}
+
+func TestSpanEvents(t *testing.T) {
+	log := logrus.New()
+	log.Out = &bytes.Buffer{}
+
+	var tracer MemoryTracer
+	ctx, root := tracer.Start(context.Background(), "root", nil)
+	ctx = WithSpanEvents(WithTracer(ctx, &tracer), &tracer)
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}}
+
+	logger.Log(ctx, msdsn.LogMessages, "info:a=1 first")
+	logger.Log(ctx, msdsn.LogMessages, "span:start name=[step]")
+	logger.Log(ctx, msdsn.LogMessages, "warning:second")
+	logger.Log(ctx, msdsn.LogMessages, "span:end")
+
+	assert.Equal(t, []MemorySpanEvent{{Name: "first", Level: logrus.InfoLevel, Attributes: map[string]any{"a": 1}}}, root.(*MemorySpan).Events)
+	assert.Equal(t, []MemorySpanEvent{{Name: "second", Level: logrus.WarnLevel}}, tracer.Spans[1].Events)
+
+	// The recorder may also be set on the logger
+	ctx, other := tracer.Start(context.Background(), "other", nil)
+	logger.SpanEvents = &tracer
+	logger.Log(ctx, msdsn.LogMessages, "info:third")
+	assert.Equal(t, []MemorySpanEvent{{Name: "third", Level: logrus.InfoLevel}}, other.(*MemorySpan).Events)
+}
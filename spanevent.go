@@ -0,0 +1,49 @@
+package sqllogging
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SpanEventRecorder records SQL log lines as events on the span active in
+// ctx. See the sqlotel package for an OpenTelemetry implementation, and
+// MemoryTracer for use in tests.
+type SpanEventRecorder interface {
+	AddEvent(ctx context.Context, name string, level logrus.Level, attributes map[string]any)
+}
+
+// WithSpanEvents makes LogrusLogger record every log line with a level prefix
+// as an event on the active span, in addition to logging it, like setting
+// LogrusLogger.SpanEvents does for all calls. The event name is the message
+// and the attributes are the fields. If spans have been started from SQL
+// (see WithTracer), the innermost one is used.
+func WithSpanEvents(ctx context.Context, recorder SpanEventRecorder) context.Context {
+	return context.WithValue(ctx, ckSpanEvents, recorder)
+}
+
+func spanEventRecorderOrNil(ctx context.Context) SpanEventRecorder {
+	val := ctx.Value(ckSpanEvents)
+	if val == nil {
+		return nil
+	}
+	return val.(SpanEventRecorder)
+}
+
+func recordSpanEvent(ctx context.Context, l LogrusLogger, level logrus.Level, m Message) {
+	recorder := spanEventRecorderOrNil(ctx)
+	if recorder == nil {
+		recorder = l.SpanEvents
+	}
+	if recorder == nil {
+		return
+	}
+	if spans := spanStackOrNil(ctx); spans != nil {
+		spans.mu.Lock()
+		if len(spans.open) > 0 {
+			ctx = spans.open[len(spans.open)-1].ctx
+		}
+		spans.mu.Unlock()
+	}
+	recorder.AddEvent(ctx, m.Text, level, m.Fields)
+}
@@ -45,20 +45,52 @@ create procedure [code].log(
     @k9 varchar(max) = null,
     @v9 sql_variant = null,
     @table varchar(max) = null,
-    @msg varchar(max) = null
+    @msg varchar(max) = null,
+    -- SQL Server has no way to find the calling procedure, so pass @@procid
+    -- here to get it logged
+    @procid int = null,
+    -- The nest level and spid of the caller and the time are logged too;
+    -- pass 0 to save the about 70 of the 2047 characters of a message
+    @caller bit = 1,
+    -- If 1, the rows of @table are sent as JSON inside the log messages instead
+    -- of in a ## table; this does not need a second connection on the Go side.
+    -- @msg may then only contain fields such as limit=50.
+    @json bit = 0
 )
 as begin
-    if @table is not null
+    declare @nonce varchar(32)
+    if @table is not null and @json = 0
     begin
         -- Copy the table into a new shared ##table. Then the caller should
         -- free the table passed in after return.
-        declare @tmptable nvarchar(max) = concat('##log', replace(lower(newid()), '-', ''))
+        declare @tmptable sysname = concat('##log', replace(lower(newid()), '-', ''))
         declare @tmptablesql nvarchar(max) = concat('select * into ', @tmptable, ' from ', quotename(@table))
         exec sp_executesql @tmptablesql
         set @table = @tmptable
+
+        -- Mark the table, so that the Go side only dumps and drops tables
+        -- created here; the nonce is passed along in the message
+        set @nonce = replace(lower(newid()), '-', '')
+        exec tempdb.sys.sp_addextendedproperty
+            @name = N'sqllogging_nonce', @value = @nonce,
+            @level0type = N'SCHEMA', @level0name = N'dbo',
+            @level1type = N'TABLE', @level1name = @tmptable
     end
 
     declare @m nvarchar(max) = concat(@level, ':')
+    if @level in ('debug', 'info', 'warning', 'error')
+    begin
+        -- Reserved fields, mapped to sql.* attributes on the Go side. The
+        -- names are quoted separately, as quotename() returns null for more
+        -- than 128 characters.
+        if @procid is not null set @m = concat(@m,
+            'sqlschema=', quotename(object_schema_name(@procid)),
+            ' sqlproc=', quotename(object_name(@procid)), ' ')
+        if @caller = 1 set @m = concat(@m,
+            'sqlnestlevel=', @@nestlevel - 1,
+            ' sqlspid=', @@spid,
+            ' sqltime=', quotename(convert(varchar(40), cast(sysdatetimeoffset() as datetimeoffset(3)), 127)), ' ')
+    end
     if @v1 is not null set @m = concat(@m, @k1, '=', [code].log_quote_value(@v1), ' ')
     if @v2 is not null set @m = concat(@m, @k2, '=', [code].log_quote_value(@v2), ' ')
     if @v3 is not null set @m = concat(@m, @k3, '=', [code].log_quote_value(@v3), ' ')
@@ -68,7 +100,102 @@ as begin
     if @v7 is not null set @m = concat(@m, @k7, '=', [code].log_quote_value(@v7), ' ')
     if @v8 is not null set @m = concat(@m, @k8, '=', [code].log_quote_value(@v8), ' ')
     if @v9 is not null set @m = concat(@m, @k9, '=', [code].log_quote_value(@v9), ' ')
-    if @table is not null set @m = concat(@m, @table, '')
+    if @table is not null and @json = 1
+    begin
+        -- Only send the rows that will be logged; limit= in @msg as on the
+        -- Go side, or else 1000, the default there
+        declare @limit int = 1000
+        declare @limitpos int = patindex('% limit=[0-9]%', concat(' ', @msg))
+        if @limitpos > 0
+        begin
+            declare @limitstr varchar(9) = substring(@msg, @limitpos + 6, 9)
+            set @limit = convert(int, left(@limitstr, patindex('%[^0-9]%', concat(@limitstr, ' ')) - 1))
+        end
+
+        declare @data nvarchar(max)
+        declare @total bigint
+        declare @jsonsql nvarchar(max) = concat(
+            'select @total = count_big(*) from ', quotename(@table), '; ',
+            'set @data = (select top(@limit) * from ', quotename(@table), ' for json path, include_null_values)')
+        exec sp_executesql @jsonsql, N'@limit int, @total bigint output, @data nvarchar(max) output',
+            @limit = @limit, @total = @total output, @data = @data output
+        set @data = isnull(@data, '[]')
+
+        -- raiserror messages are limited to 2047 characters, and % must be
+        -- escaped as %% which may double the length of a chunk. Longer
+        -- messages would be truncated, so give up if the fields and @msg
+        -- leave too little room.
+        declare @dumpid varchar(32) = replace(lower(newid()), '-', '')
+        declare @header nvarchar(max) = concat(@m,
+            'dumpid=[', @dumpid, '] dumppart=', 2147483647, ' dumpparts=', 2147483647,
+            ' dumptotal=', @total, ' dumptable=', quotename(@table), ' ', @msg, ' |')
+        declare @chunksize int = (2047 - (len(concat(@header, 'x')) - 1)) / 2
+        if @chunksize < 100
+        begin
+            set @m = concat('warning:Unable to log table ', @table, ' as JSON: the fields and @msg leave too little room in the message')
+            raiserror (@m, 0, 0) with nowait
+            return
+        end
+        declare @parts int = (len(@data) + @chunksize - 1) / @chunksize
+        declare @part int = 1
+        declare @chunk nvarchar(max)
+        while @part <= @parts
+        begin
+            set @chunk = concat(@m,
+                'dumpid=[', @dumpid, '] dumppart=', @part, ' dumpparts=', @parts,
+                ' dumptotal=', @total, ' dumptable=', quotename(@table), ' ', @msg, ' |',
+                replace(substring(@data, (@part - 1) * @chunksize + 1, @chunksize), '%', '%%'))
+            raiserror (@chunk, 0, 0) with nowait
+            set @part = @part + 1
+        end
+        return
+    end
+
+    if @table is not null set @m = concat(@m, @table, ' dumpnonce=', quotename(@nonce), ' ')
     if @msg is not null set @m = concat(@m, @msg, ' ')
     raiserror (@m, 0, 0) with nowait
 end
+
+go
+
+-- Logs the rows added, removed and changed between two snapshots of a
+-- table, matched on the comma-separated key columns in @key:
+--
+--   select * into #before from dbo.Account
+--   exec dbo.MergeAccounts
+--   select * into #after from dbo.Account
+--   exec [code].log_diff @before = '#before', @after = '#after', @key = 'id'
+--
+-- Like with [code].log, the caller should free the tables passed in after
+-- return; @msg may contain fields such as limit=5000.
+create procedure [code].log_diff(
+    @before sysname,
+    @after sysname,
+    @key varchar(max),
+    @level varchar(max) = 'info',
+    @msg varchar(max) = null
+)
+as begin
+    declare @nonce varchar(32) = replace(lower(newid()), '-', '')
+    declare @beforetable sysname = concat('##log', replace(lower(newid()), '-', ''))
+    declare @aftertable sysname = concat('##log', replace(lower(newid()), '-', ''))
+    declare @tmptablesql nvarchar(max) = concat(
+        'select * into ', @beforetable, ' from ', quotename(@before), '; ',
+        'select * into ', @aftertable, ' from ', quotename(@after))
+    exec sp_executesql @tmptablesql
+
+    exec tempdb.sys.sp_addextendedproperty
+        @name = N'sqllogging_nonce', @value = @nonce,
+        @level0type = N'SCHEMA', @level0name = N'dbo',
+        @level1type = N'TABLE', @level1name = @beforetable
+    exec tempdb.sys.sp_addextendedproperty
+        @name = N'sqllogging_nonce', @value = @nonce,
+        @level0type = N'SCHEMA', @level0name = N'dbo',
+        @level1type = N'TABLE', @level1name = @aftertable
+
+    declare @m nvarchar(max) = concat(@level, ':', @beforetable,
+        ' dumpnonce=', quotename(@nonce),
+        ' diffwith=', quotename(@aftertable),
+        ' diffkey=', quotename(@key), ' ', @msg)
+    raiserror (@m, 0, 0) with nowait
+end
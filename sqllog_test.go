@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -78,17 +79,21 @@ func TestLogrusLogging(t *testing.T) {
 
 	logbuf.Reset()
 	_, err = conn.ExecContext(With(ctx, logger, dbi), `
-	select x, concat('number ', x) as y into ##log1
+	select x, concat('number ', x) as y into ##log0123456789abcdef0123456789abcdef
 	from (values (1), (2)) row(x)
 	;
-	raiserror ('debug:##log1', 0, 0) with nowait;
+	exec tempdb.sys.sp_addextendedproperty
+		@name = N'sqllogging_nonce', @value = 'n1',
+		@level0type = N'SCHEMA', @level0name = N'dbo',
+		@level1type = N'TABLE', @level1name = N'##log0123456789abcdef0123456789abcdef';
+	raiserror ('debug:##log0123456789abcdef0123456789abcdef dumpnonce=[n1]', 0, 0) with nowait;
 `)
 	require.NoError(t, err)
 
 	// ##-table should be dropped
 	var dummy int
-	err = conn.QueryRowContext(ctx, `select x from ##log1`).Scan(&dummy)
-	assert.Contains(t, err.Error(), "Invalid object name '##log1'")
+	err = conn.QueryRowContext(ctx, `select x from ##log0123456789abcdef0123456789abcdef`).Scan(&dummy)
+	assert.Contains(t, err.Error(), "Invalid object name '##log0123456789abcdef0123456789abcdef'")
 	assert.Equal(t, ``+
 		`{"intest":true,"level":"debug","msg":"","time":"2000-01-01T00:00:00Z","x":1,"y":"number 1"}
 {"intest":true,"level":"debug","msg":"","time":"2000-01-01T00:00:00Z","x":2,"y":"number 2"}
@@ -110,6 +115,10 @@ func TestStdErr(t *testing.T) {
 		Querier:  dbi,
 		Fallback: StandardFallbackLogrusMssqlLogger{},
 		Stderr:   &stderr,
+		TableDumpPolicy: TableDumpPolicy{
+			Pattern:          regexp.MustCompile(`^##log1$`),
+			SkipVerification: true,
+		},
 	})
 
 	_, err := dbi.ExecContext(qryCtx, `
@@ -0,0 +1,65 @@
+// Package sqlotel adapts OpenTelemetry tracing to the Tracer interface of
+// go-sqllogging, so that spans started from SQL show up in OpenTelemetry
+// traces.
+package sqlotel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	sqllogging "github.com/vippsas/go-sqllogging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer wraps an OpenTelemetry tracer; use it with sqllogging.WithTracer.
+type Tracer struct {
+	Tracer trace.Tracer
+}
+
+func (t Tracer) Start(ctx context.Context, name string, attributes map[string]any) (context.Context, sqllogging.Span) {
+	ctx, span := t.Tracer.Start(ctx, name, trace.WithAttributes(Attributes(attributes)...))
+	return ctx, Span{Span: span}
+}
+
+type Span struct {
+	Span trace.Span
+}
+
+func (s Span) SetAttributes(attributes map[string]any) {
+	s.Span.SetAttributes(Attributes(attributes)...)
+}
+
+func (s Span) End() {
+	s.Span.End()
+}
+
+// SpanEvents records SQL log lines as events on the OpenTelemetry span active
+// in the context; set it as sqllogging.LogrusLogger.SpanEvents, or use it
+// with sqllogging.WithSpanEvents.
+type SpanEvents struct{}
+
+func (SpanEvents) AddEvent(ctx context.Context, name string, level logrus.Level, attributes map[string]any) {
+	attrs := append(Attributes(attributes), attribute.String("level", level.String()))
+	trace.SpanFromContext(ctx).AddEvent(name, trace.WithAttributes(attrs...))
+}
+
+// Attributes converts fields parsed from SQL log messages to OpenTelemetry
+// attributes.
+func Attributes(fields map[string]any) []attribute.KeyValue {
+	result := make([]attribute.KeyValue, 0, len(fields))
+	for k, v := range fields {
+		switch v := v.(type) {
+		case nil:
+			result = append(result, attribute.String(k, ""))
+		case int:
+			result = append(result, attribute.Int(k, v))
+		case string:
+			result = append(result, attribute.String(k, v))
+		default:
+			result = append(result, attribute.String(k, fmt.Sprint(v)))
+		}
+	}
+	return result
+}
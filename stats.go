@@ -0,0 +1,88 @@
+package sqllogging
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+)
+
+// Stats accumulates the rows affected per statement and the retries of
+// calls made with a context, from the driver's LogRows and LogRetries
+// categories. Include these in the "log=" DSN parameter and attach the
+// Stats with WithStats; after the call, read it with Summary or LogSummary.
+// This replaces issuing extra "select @@rowcount" just for logging.
+type Stats struct {
+	mu      sync.Mutex
+	summary StatsSummary
+}
+
+type StatsSummary struct {
+	Statements   int      // number of statements reporting a row count
+	RowsAffected []int64  // rows affected, per statement
+	Retries      []string // the reason for each retry
+}
+
+// WithStats attaches stats to ctx; it is used by LogrusLogger.
+func WithStats(ctx context.Context, stats *Stats) context.Context {
+	return context.WithValue(ctx, ckStats, stats)
+}
+
+func StatsOrNil(ctx context.Context) *Stats {
+	val := ctx.Value(ckStats)
+	if val == nil {
+		return nil
+	}
+	return val.(*Stats)
+}
+
+func (s *Stats) log(category msdsn.Log, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch category {
+	case msdsn.LogRows:
+		// The driver logs "(%d rows affected)" or "(%d row(s) affected)"
+		numstr, _, _ := strings.Cut(strings.TrimPrefix(msg, "("), " ")
+		n, err := strconv.ParseInt(numstr, 10, 64)
+		if err != nil {
+			return
+		}
+		s.summary.Statements++
+		s.summary.RowsAffected = append(s.summary.RowsAffected, n)
+	case msdsn.LogRetries:
+		s.summary.Retries = append(s.summary.Retries, msg)
+	}
+}
+
+// Summary returns a copy of what has been accumulated so far.
+func (s *Stats) Summary() StatsSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return StatsSummary{
+		Statements:   s.summary.Statements,
+		RowsAffected: append([]int64(nil), s.summary.RowsAffected...),
+		Retries:      append([]string(nil), s.summary.Retries...),
+	}
+}
+
+// LogSummary writes the summary as a single log line.
+func (s *Stats) LogSummary(logger logrus.FieldLogger, level logrus.Level) {
+	summary := s.Summary()
+	var total int64
+	for _, n := range summary.RowsAffected {
+		total += n
+	}
+	fields := logrus.Fields{
+		"statements":          summary.Statements,
+		"rows_affected":       summary.RowsAffected,
+		"rows_affected_total": total,
+		"retries":             len(summary.Retries),
+	}
+	if len(summary.Retries) > 0 {
+		fields["retry_reasons"] = summary.Retries
+	}
+	logAtLevel(logger.WithFields(fields), level, "sql call summary")
+}
@@ -0,0 +1,81 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	var stats Stats
+	ctx := WithStats(context.Background(), &stats)
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}}
+
+	logger.Log(ctx, msdsn.LogRows, "(3 rows affected)")
+	logger.Log(ctx, msdsn.LogRetries, "connection reset")
+	logger.Log(ctx, msdsn.LogRows, "(0 row(s) affected)")
+	logger.Log(ctx, msdsn.LogRows, "(10 row(s) affected)")
+	assert.Equal(t, "", logbuf.String())
+
+	assert.Equal(t, StatsSummary{
+		Statements:   3,
+		RowsAffected: []int64{3, 0, 10},
+		Retries:      []string{"connection reset"},
+	}, StatsOrNil(ctx).Summary())
+
+	stats.LogSummary(log, logrus.InfoLevel)
+	assert.Equal(t,
+		`{"level":"info","msg":"sql call summary","retries":1,"retry_reasons":["connection reset"],"rows_affected":[3,0,10],"rows_affected_total":13,"statements":3}`+"\n",
+		logbuf.String())
+}
+
+// loggingQuerier logs like the driver would for each query, and fails it
+type loggingQuerier struct{}
+
+func (q loggingQuerier) log(ctx context.Context, query string) {
+	mssqlLogHook{}.Log(ctx, msdsn.LogSQL, query)
+	mssqlLogHook{}.Log(ctx, msdsn.LogRows, "(1 row affected)")
+}
+
+func (q loggingQuerier) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	q.log(ctx, query)
+	return nil, errors.New("boom")
+}
+
+func (q loggingQuerier) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	panic("not implemented")
+}
+
+func (q loggingQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	q.log(ctx, query)
+	return nil, nil
+}
+
+func TestStatsIgnoreTableDumps(t *testing.T) {
+	log := logrus.New()
+	log.Out = &bytes.Buffer{}
+	logger := LogrusLogger{
+		Logger:          log,
+		Fallback:        StandardFallbackLogrusMssqlLogger{},
+		Querier:         loggingQuerier{},
+		TableDumpPolicy: TableDumpPolicy{Pattern: regexp.MustCompile(`^##dump`), SkipVerification: true},
+	}
+	var stats Stats
+	ctx := WithLogger(WithStats(context.Background(), &stats), logger)
+
+	// The queries made to dump and drop the table are not counted
+	logger.Log(ctx, msdsn.LogMessages, "info:##dump1")
+	assert.Equal(t, StatsSummary{}, stats.Summary())
+}
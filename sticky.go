@@ -0,0 +1,77 @@
+package sqllogging
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// StickyFields holds fields set from SQL with "fields:set", which are added
+// to every later log line of the call:
+//
+//	fields:set tenant=[acme] batch=42
+//	fields:clear tenant=
+//	fields:clear
+//
+// "fields:clear" without fields clears all of them. The action may also come
+// after the fields, as [code].log puts it:
+//
+//	exec [code].log 'fields', 'tenant', 'acme', @msg = 'set'
+type StickyFields struct {
+	mu     sync.Mutex
+	fields logrus.Fields
+}
+
+func (s *StickyFields) apply(logger logrus.FieldLogger) logrus.FieldLogger {
+	if s == nil {
+		return logger
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.fields) == 0 {
+		return logger
+	}
+	return logger.WithFields(s.fields)
+}
+
+func init() {
+	RegisterPrefix("fields", stickyFieldsHandler)
+}
+
+func stickyFieldsHandler(ctx context.Context, l LogrusLogger, m Message) {
+	s := l.Sticky
+	if s == nil {
+		return
+	}
+	action, fields := parseAction(m, "set", "clear")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch action {
+	case "set":
+		// copy, as loggers made by apply() share the map
+		updated := make(logrus.Fields, len(s.fields)+len(fields))
+		for k, v := range s.fields {
+			updated[k] = v
+		}
+		for k, v := range fields {
+			updated[k] = v
+		}
+		s.fields = updated
+	case "clear":
+		if fields == nil {
+			s.fields = nil
+			return
+		}
+		updated := make(logrus.Fields, len(s.fields))
+		for k, v := range s.fields {
+			if _, ok := fields[k]; !ok {
+				updated[k] = v
+			}
+		}
+		s.fields = updated
+	default:
+		m.Logger.Warning("fields: from SQL with unknown action " + action)
+	}
+}
@@ -0,0 +1,36 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStickyFields(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	ctx := context.Background()
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}, Sticky: &StickyFields{}}
+
+	logger.Log(ctx, msdsn.LogMessages, "fields:set tenant=[acme] batch=42")
+	logger.Log(ctx, msdsn.LogMessages, "info:a=1 first")
+	logger.Log(ctx, msdsn.LogMessages, "info:batch=43 override")
+	logger.Log(ctx, msdsn.LogMessages, "fields:batch= clear ")
+	logger.Log(ctx, msdsn.LogMessages, "info:second")
+	logger.Log(ctx, msdsn.LogMessages, "fields:clear")
+	logger.Log(ctx, msdsn.LogMessages, "info:third")
+
+	assert.Equal(t, ``+
+		`{"a":1,"batch":42,"level":"info","msg":"first","tenant":"acme"}
+{"batch":43,"level":"info","msg":"override","tenant":"acme"}
+{"level":"info","msg":"second","tenant":"acme"}
+{"level":"info","msg":"third"}
+`, logbuf.String())
+}
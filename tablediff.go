@@ -0,0 +1,242 @@
+package sqllogging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Table diffs are logged by [code].log_diff, which copies two snapshot
+// tables to ##log tables and names both in a single message:
+//
+//	info:##log1... dumpnonce=[...] diffwith=[##log2...] diffkey=[id]
+//
+// The rows are matched on the key columns, and the rows added, removed and
+// changed are logged instead of both tables.
+
+// TableDiff is the difference between the tables Before and After
+type TableDiff struct {
+	Before, After string
+	Key           []string
+	Added         TableDump // rows only in After
+	Removed       TableDump // rows only in Before
+	Changed       []RowChange
+}
+
+// RowChange is a row that is in both tables, but with different values
+type RowChange struct {
+	Key     Row      // values of the key columns
+	Columns []string // the columns that changed
+	Old     Row      // values of Columns in Before
+	New     Row      // values of Columns in After
+}
+
+func parseDiffKey(key string) []string {
+	var columns []string
+	for _, column := range strings.Split(key, ",") {
+		if column = strings.TrimSpace(column); column != "" {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// tables returns the ##log tables of the dump or diff
+func (o TableDumpOptions) tables(tablename string) []string {
+	if o.diffWith == "" {
+		return []string{tablename}
+	}
+	return []string{tablename, o.diffWith}
+}
+
+func columnIndexes(dump TableDump) map[string]int {
+	indexes := make(map[string]int, len(dump.Columns))
+	for i, column := range dump.Columns {
+		indexes[column] = i
+	}
+	return indexes
+}
+
+// rowKey returns the values of the key columns of row, and a string
+// identifying them
+func rowKey(dump TableDump, indexes map[string]int, key []string, row Row) (Row, string, error) {
+	values := make(Row, len(key))
+	for i, column := range key {
+		j, ok := indexes[column]
+		if !ok {
+			return nil, "", fmt.Errorf("key column %s not in %s", column, dump.Name)
+		}
+		values[i] = row[j]
+	}
+	id, err := json.Marshal(values)
+	return values, string(id), err
+}
+
+func diffTables(before, after TableDump, key []string) (diff TableDiff, err error) {
+	if len(key) == 0 {
+		return diff, errors.New("no key columns")
+	}
+	diff = TableDiff{
+		Before:  before.Name,
+		After:   after.Name,
+		Key:     key,
+		Added:   TableDump{Name: "added", Columns: after.Columns},
+		Removed: TableDump{Name: "removed", Columns: before.Columns},
+	}
+	beforeIndexes, afterIndexes := columnIndexes(before), columnIndexes(after)
+
+	beforeRows := make(map[string]Row, len(before.Rows))
+	for _, row := range before.Rows {
+		_, id, err := rowKey(before, beforeIndexes, key, row)
+		if err != nil {
+			return diff, err
+		}
+		beforeRows[id] = row
+	}
+
+	// Columns in both tables are compared; a column in only one of them
+	// counts as NULL in the other
+	columns := append([]string{}, before.Columns...)
+	for _, column := range after.Columns {
+		if _, ok := beforeIndexes[column]; !ok {
+			columns = append(columns, column)
+		}
+	}
+	valueOf := func(indexes map[string]int, row Row, column string) any {
+		if i, ok := indexes[column]; ok {
+			return row[i]
+		}
+		return nil
+	}
+
+	matched := make(map[string]bool, len(after.Rows))
+	for _, row := range after.Rows {
+		keyValues, id, err := rowKey(after, afterIndexes, key, row)
+		if err != nil {
+			return diff, err
+		}
+		old, ok := beforeRows[id]
+		if !ok {
+			diff.Added.Rows = append(diff.Added.Rows, row)
+			continue
+		}
+		matched[id] = true
+		change := RowChange{Key: keyValues}
+		for _, column := range columns {
+			oldValue, newValue := valueOf(beforeIndexes, old, column), valueOf(afterIndexes, row, column)
+			if !reflect.DeepEqual(oldValue, newValue) {
+				change.Columns = append(change.Columns, column)
+				change.Old = append(change.Old, oldValue)
+				change.New = append(change.New, newValue)
+			}
+		}
+		if len(change.Columns) > 0 {
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+	for _, row := range before.Rows {
+		_, id, _ := rowKey(before, beforeIndexes, key, row)
+		if !matched[id] {
+			diff.Removed.Rows = append(diff.Removed.Rows, row)
+		}
+	}
+	diff.Added.RowsTotal = int64(len(diff.Added.Rows))
+	diff.Removed.RowsTotal = int64(len(diff.Removed.Rows))
+	return diff, nil
+}
+
+// queryTableDiff dumps both tables ordered by the key, and diffs them. The
+// diff would be wrong if rows were omitted, so that is an error. Redacted
+// values could collide or hide changes, so the rows are only redacted
+// after diffing.
+func queryTableDiff(ctx context.Context, dbi QuerierExecer, tablename string, opts TableDumpOptions) (diff TableDiff, err error) {
+	redaction := opts.redaction
+	opts.Unordered, opts.Random, opts.OrderBy = false, false, opts.diffKey
+	opts.redaction = nil
+	before, err := queryTableDump(ctx, dbi, tablename, opts)
+	if err != nil {
+		return diff, err
+	}
+	after, err := queryTableDump(ctx, dbi, opts.diffWith, opts)
+	if err != nil {
+		return diff, err
+	}
+	if before.RowsOmitted > 0 || after.RowsOmitted > 0 {
+		return diff, fmt.Errorf("more than %d rows in the tables", opts.limit())
+	}
+	diff, err = diffTables(before, after, opts.diffKey)
+	return redaction.redactDiff(diff), err
+}
+
+// Log the difference between two tables; one entry per row added, removed
+// or changed, with the field diff telling which. The columns go under their
+// own field, so that they cannot collide with diff.
+func tableDiffStructured(ctx context.Context, logger logrus.FieldLogger, level logrus.Level, dbi QuerierExecer, tablename string, opts TableDumpOptions) {
+	diff, err := queryTableDiff(ctx, dbi, tablename, opts)
+	if err != nil {
+		logger.Warning("Unable to log diff of tables " + tablename + " and " + opts.diffWith + ": " + err.Error())
+		return
+	}
+	logTableDiff(logger, level, diff)
+}
+
+func logTableDiff(logger logrus.FieldLogger, level logrus.Level, diff TableDiff) {
+	logRows := func(dump TableDump, kind string) {
+		for _, row := range dump.Rows {
+			values := make(map[string]any, len(row))
+			for i, value := range row {
+				values[dump.Columns[i]] = value
+			}
+			logAtLevel(logger.WithFields(logrus.Fields{"diff": kind, "row": values}), level, "")
+		}
+	}
+	logRows(diff.Removed, "removed")
+	logRows(diff.Added, "added")
+	for _, change := range diff.Changed {
+		changes := make(map[string]map[string]any, len(change.Columns))
+		for i, column := range change.Columns {
+			changes[column] = map[string]any{"old": change.Old[i], "new": change.New[i]}
+		}
+		key := make(map[string]any, len(diff.Key))
+		for i, column := range diff.Key {
+			key[column] = change.Key[i]
+		}
+		logAtLevel(logger.WithFields(logrus.Fields{"diff": "changed", "key": key, "changes": changes}), level, "")
+	}
+}
+
+// Write the difference between two tables to stream in human-readable form
+func tableDiffPrettyPrint(ctx context.Context, w io.Writer, dbi QuerierExecer, tablename string, opts TableDumpOptions) {
+	diff, err := queryTableDiff(ctx, dbi, tablename, opts)
+	if err != nil {
+		_, _ = fmt.Fprintln(w, tablename+" -> "+opts.diffWith+": "+err.Error())
+		return
+	}
+	renderTableDiff(w, diff, opts)
+}
+
+// renderTableDiff renders the rows removed and added, and the changes with
+// one row per changed column, with the TableRenderer of opts
+func renderTableDiff(w io.Writer, diff TableDiff, opts TableDumpOptions) {
+	_, _ = fmt.Fprintf(w, "%s -> %s by %s: %d removed, %d added, %d changed\n",
+		diff.Before, diff.After, strings.Join(diff.Key, ", "), len(diff.Removed.Rows), len(diff.Added.Rows), len(diff.Changed))
+	changed := TableDump{Name: "changed", Columns: append(append([]string{}, diff.Key...), "column", "old", "new")}
+	for _, change := range diff.Changed {
+		for i, column := range change.Columns {
+			row := append(append(Row{}, change.Key...), column, change.Old[i], change.New[i])
+			changed.Rows = append(changed.Rows, row)
+		}
+	}
+	changed.RowsTotal = int64(len(changed.Rows))
+	for _, dump := range []TableDump{diff.Removed, diff.Added, changed} {
+		if len(dump.Rows) > 0 {
+			renderTableDump(w, dump, opts)
+		}
+	}
+}
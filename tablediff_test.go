@@ -0,0 +1,128 @@
+package sqllogging
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTableDiff(t *testing.T) TableDiff {
+	before := TableDump{
+		Name:    "##logbefore",
+		Columns: []string{"id", "name", "balance"},
+		Rows: []Row{
+			{1, "a", json.Number("1.00")},
+			{2, "b", json.Number("2.00")},
+			{3, "c", json.Number("3.00")},
+		},
+	}
+	after := TableDump{
+		Name:    "##logafter",
+		Columns: []string{"id", "name", "balance", "status"},
+		Rows: []Row{
+			{1, "a", json.Number("1.00"), nil},
+			{3, "C", json.Number("4.00"), "open"},
+			{4, "d", json.Number("0.00"), nil},
+		},
+	}
+	diff, err := diffTables(before, after, []string{"id"})
+	require.NoError(t, err)
+	return diff
+}
+
+func TestDiffTables(t *testing.T) {
+	diff := testTableDiff(t)
+	assert.Equal(t, []Row{{2, "b", json.Number("2.00")}}, diff.Removed.Rows)
+	assert.Equal(t, []Row{{4, "d", json.Number("0.00"), nil}}, diff.Added.Rows)
+	assert.Equal(t, []RowChange{{
+		Key:     Row{3},
+		Columns: []string{"name", "balance", "status"},
+		Old:     Row{"c", json.Number("3.00"), nil},
+		New:     Row{"C", json.Number("4.00"), "open"},
+	}}, diff.Changed)
+
+	_, err := diffTables(TableDump{Name: "##log1", Columns: []string{"x"}, Rows: []Row{{1}}}, TableDump{}, []string{"id"})
+	assert.EqualError(t, err, "key column id not in ##log1")
+
+	_, opts, ok := parseTableDump("##log1 dumpnonce=[n] diffwith=[##log2] diffkey=[id, version]", TableDumpOptions{})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"##log1", "##log2"}, opts.tables("##log1"))
+	assert.Equal(t, []string{"id", "version"}, opts.diffKey)
+}
+
+func TestRedactDiff(t *testing.T) {
+	// Diffed before redaction, so that masked keys do not collide and
+	// masked changes are kept
+	before := TableDump{
+		Name:    "##logbefore",
+		Columns: []string{"card", "holder"},
+		Rows:    []Row{{"4111111111111111", "Kari"}, {"5555555555554444", "Ola"}},
+	}
+	after := TableDump{
+		Name:    "##logafter",
+		Columns: []string{"card", "holder"},
+		Rows:    []Row{{"4111111111111111", "Kari Nordmann"}, {"4000056655665556", "Per"}},
+	}
+	diff, err := diffTables(before, after, []string{"card"})
+	require.NoError(t, err)
+	policy := &RedactionPolicy{
+		Keys:   []*regexp.Regexp{regexp.MustCompile(`^holder$`)},
+		Values: []*regexp.Regexp{CardNumberPattern},
+	}
+	diff = policy.redactDiff(diff)
+	assert.Equal(t, []Row{{"[REDACTED]", "[REDACTED]"}}, diff.Removed.Rows)
+	assert.Equal(t, []Row{{"[REDACTED]", "[REDACTED]"}}, diff.Added.Rows)
+	assert.Equal(t, []RowChange{{
+		Key:     Row{"[REDACTED]"},
+		Columns: []string{"holder"},
+		Old:     Row{"[REDACTED]"},
+		New:     Row{"[REDACTED]"},
+	}}, diff.Changed)
+}
+
+func TestLogTableDiff(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	logTableDiff(log, logrus.InfoLevel, testTableDiff(t))
+	assert.Equal(t, `{"diff":"removed","level":"info","msg":"","row":{"balance":2.00,"id":2,"name":"b"}}
+{"diff":"added","level":"info","msg":"","row":{"balance":0.00,"id":4,"name":"d","status":null}}
+{"changes":{"balance":{"new":4.00,"old":3.00},"name":{"new":"C","old":"c"},"status":{"new":"open","old":null}},"diff":"changed","key":{"id":3},"level":"info","msg":""}
+`, logbuf.String())
+
+	// Columns named like the fields of the diff do not replace them
+	logbuf.Reset()
+	before := TableDump{Name: "##logbefore", Columns: []string{"diff", "changes"}, Rows: []Row{{1, "x"}, {2, "y"}}}
+	after := TableDump{Name: "##logafter", Columns: []string{"diff", "changes"}, Rows: []Row{{2, "z"}}}
+	diff, err := diffTables(before, after, []string{"diff"})
+	require.NoError(t, err)
+	logTableDiff(log, logrus.InfoLevel, diff)
+	assert.Equal(t, `{"diff":"removed","level":"info","msg":"","row":{"changes":"x","diff":1}}
+{"changes":{"changes":{"new":"z","old":"y"}},"diff":"changed","key":{"diff":2},"level":"info","msg":""}
+`, logbuf.String())
+}
+
+func TestRenderTableDiff(t *testing.T) {
+	var out bytes.Buffer
+	renderTableDiff(&out, testTableDiff(t), TableDumpOptions{Format: "csv"})
+	assert.Equal(t, strings.Join([]string{
+		"##logbefore -> ##logafter by id: 1 removed, 1 added, 1 changed",
+		"id,name,balance",
+		"2,b,2.00",
+		"id,name,balance,status",
+		"4,d,0.00,",
+		"id,column,old,new",
+		"3,name,c,C",
+		"3,balance,3.00,4.00",
+		"3,status,,open",
+		"",
+	}, "\n"), out.String())
+}
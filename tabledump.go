@@ -3,13 +3,14 @@ package sqllogging
 import (
 	"context"
 	"database/sql"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	mssql "github.com/denisenkom/go-mssqldb"
 	"github.com/sirupsen/logrus"
 	"io"
-	"text/tabwriter"
-	"time"
-
-	"github.com/alecthomas/repr"
+	"strconv"
+	"strings"
 )
 
 type Row []any
@@ -32,19 +33,8 @@ func scanRowsOfAny(rows *sql.Rows, next func(Row) error) error {
 		}
 
 		var row Row
-		for i, _ := range types {
-			switch v := rowValues[i].(type) {
-			case []uint8:
-				row = append(row, string(v))
-			case int64:
-				// we don't really know that the query column is int64, that's just how all ints are returned,
-				// and it's usually more convenient in tests with int
-				row = append(row, int(v))
-			case time.Time:
-				row = append(row, v)
-			default:
-				row = append(row, v)
-			}
+		for i, typ := range types {
+			row = append(row, convertColumnValue(typ.DatabaseTypeName(), rowValues[i]))
 		}
 		err = next(row)
 		if err != nil {
@@ -54,89 +44,303 @@ func scanRowsOfAny(rows *sql.Rows, next func(Row) error) error {
 	return rows.Err()
 }
 
-func sqlQueryLogTable(tablename string) string {
-	return "select top(1000) * from " + sqlQuotename(tablename) + " order by 1"
+// convertColumnValue converts values as returned by the driver to something
+// that shows the value faithfully in logs:
+//   - decimal and money become json.Number, which is exact and logged as a number
+//   - binary becomes a "0x..." hex string
+//   - uniqueidentifier becomes its textual form, not the mixed-endian bytes
+//   - datetimeoffset is a time.Time that keeps its offset, as from the driver
+func convertColumnValue(typeName string, value any) any {
+	switch v := value.(type) {
+	case []uint8:
+		switch typeName {
+		case "DECIMAL", "MONEY", "SMALLMONEY":
+			return json.Number(v)
+		case "VARBINARY", "BINARY", "IMAGE":
+			return "0x" + strings.ToUpper(hex.EncodeToString(v))
+		case "UNIQUEIDENTIFIER":
+			var u mssql.UniqueIdentifier
+			if err := u.Scan(v); err == nil {
+				return u.String()
+			}
+			return "0x" + strings.ToUpper(hex.EncodeToString(v))
+		default:
+			return string(v)
+		}
+	case int64:
+		// we don't really know that the query column is int64, that's just how all ints are returned,
+		// and it's usually more convenient in tests with int
+		return int(v)
+	default:
+		return v
+	}
 }
 
-// Dump contents of table to stream in human-readable column form
-func tableDumpPrettyPrint(ctx context.Context, w io.Writer, dbi QuerierExecer, tablename string) {
-	_, _ = fmt.Fprintln(w, "================================")
-	_, _ = fmt.Fprintln(w, tablename)
-	_, _ = fmt.Fprintln(w, "================================")
+// TableDumpOptions controls which rows of a ##log table are dumped. SQL may
+// override them per dump by following the table name with fields:
+//
+//	info:##log1 limit=50 order=[b desc, a] sample=[random]
+//	stderr:##log1 format=[grid]
+//	info:##log1 mode=[single]
+//
+// where order=[none] means no ordering, and mode=[single] or mode=[rows]
+// sets SingleEntry.
+type TableDumpOptions struct {
+	Limit     int      // maximum number of rows; 0 means DefaultTableDumpLimit
+	OrderBy   []string // columns, optionally followed by "asc" or "desc"; nil means the first column
+	Unordered bool     // do not order the rows; takes precedence over OrderBy
+	Random    bool     // dump a random sample of Limit rows instead of the first ones
+	Format    string   // name of the TableRenderer for "stderr:" dumps; "" means "vertical"
+	// SingleEntry makes structured dumps be one log entry with the fields
+	// table, columns, rows (an array of objects) and row_count, instead of
+	// one entry per row. If the rows take more than SingleEntryMaxBytes as
+	// JSON, one entry per row is used anyway; 0 means DefaultSingleEntryMaxBytes.
+	SingleEntry         bool
+	SingleEntryMaxBytes int
 
-	rows, err := dbi.QueryContext(ctx, sqlQueryLogTable(tablename))
+	nonce     string           // dumpnonce from the message, see TableDumpPolicy
+	redaction *RedactionPolicy // from LogrusLogger.Redaction
+	diffWith  string           // the after table of a diff, see TableDiff
+	diffKey   []string
+}
+
+const DefaultSingleEntryMaxBytes = 64 * 1024
+
+const DefaultTableDumpLimit = 1000
+
+// parseTableDump checks if msg asks for a table dump, and returns the table
+// name and the options with any overrides from msg applied.
+func parseTableDump(msg string, opts TableDumpOptions) (tablename string, result TableDumpOptions, ok bool) {
+	tablename, rest, _ := strings.Cut(strings.TrimSpace(msg), " ")
+	if !logTableNameRegexp.MatchString(tablename) {
+		return "", opts, false
+	}
+	fields, remainder := parseFields(rest)
+	if strings.TrimSpace(remainder) != "" {
+		return "", opts, false
+	}
+	return tablename, opts.withOverrides(fields), true
+}
+
+var tableDumpOptionFields = []string{"limit", "order", "sample", "format", "mode", "dumpnonce", "diffwith", "diffkey"}
+
+// withOverrides applies the per dump overrides in fields
+func (opts TableDumpOptions) withOverrides(fields logrus.Fields) TableDumpOptions {
+	for k, v := range fields {
+		switch k {
+		case "limit":
+			if n, isInt := v.(int); isInt && n > 0 {
+				opts.Limit = n
+			}
+		case "order":
+			if order, isString := v.(string); isString {
+				if order == "none" {
+					opts.Unordered = true
+				} else {
+					opts.Unordered = false
+					opts.OrderBy = strings.Split(order, ",")
+				}
+			}
+		case "sample":
+			opts.Random = v == "random"
+		case "format":
+			if format, isString := v.(string); isString {
+				opts.Format = format
+			}
+		case "mode":
+			switch v {
+			case "single":
+				opts.SingleEntry = true
+			case "rows":
+				opts.SingleEntry = false
+			}
+		case "dumpnonce":
+			if nonce, isString := v.(string); isString {
+				opts.nonce = nonce
+			}
+		case "diffwith":
+			if table, isString := v.(string); isString && logTableNameRegexp.MatchString(table) {
+				opts.diffWith = table
+			}
+		case "diffkey":
+			if key, isString := v.(string); isString {
+				opts.diffKey = parseDiffKey(key)
+			}
+		}
+	}
+	return opts
+}
+
+func (o TableDumpOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultTableDumpLimit
+	}
+	return o.Limit
+}
+
+func (o TableDumpOptions) orderByClause() string {
+	if o.Unordered {
+		return ""
+	}
+	if len(o.OrderBy) == 0 {
+		return " order by 1"
+	}
+	var terms []string
+	for _, term := range o.OrderBy {
+		words := strings.Fields(term)
+		if len(words) == 0 {
+			continue
+		}
+		quoted := sqlQuotename(words[0])
+		if len(words) > 1 && (strings.EqualFold(words[1], "desc") || strings.EqualFold(words[1], "asc")) {
+			quoted += " " + strings.ToLower(words[1])
+		}
+		terms = append(terms, quoted)
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	return " order by " + strings.Join(terms, ", ")
+}
+
+func sqlQueryLogTable(tablename string, opts TableDumpOptions) string {
+	top := "select top(" + strconv.Itoa(opts.limit()) + ") * from " + sqlQuotename(tablename)
+	if opts.Random {
+		return "select * from (" + top + " order by newid()) sample" + opts.orderByClause()
+	}
+	return top + opts.orderByClause()
+}
+
+// countOmittedRows returns the number of rows in the table and how many of
+// them were not dumped, if the dump was truncated
+func countOmittedRows(ctx context.Context, dbi QuerierExecer, tablename string, opts TableDumpOptions, dumped int) (total, omitted int64, err error) {
+	if dumped < opts.limit() {
+		return int64(dumped), 0, nil
+	}
+	err = dbi.QueryRowContext(withoutSqllogging(ctx), "select count_big(*) from "+sqlQuotename(tablename)).Scan(&total)
 	if err != nil {
-		_, _ = fmt.Fprintln(w, err.Error())
-		return
+		return 0, 0, err
+	}
+	return total, total - int64(dumped), nil
+}
+
+// TableDump is the contents of a ##log table, as passed to a TableRenderer
+type TableDump struct {
+	Name        string
+	Columns     []string
+	Rows        []Row
+	RowsTotal   int64 // number of rows in the table
+	RowsOmitted int64 // number of rows not in Rows because of TableDumpOptions
+}
+
+func queryTableDump(ctx context.Context, dbi QuerierExecer, tablename string, opts TableDumpOptions) (dump TableDump, err error) {
+	dump.Name = tablename
+	ctx = withoutSqllogging(ctx)
+	rows, err := dbi.QueryContext(ctx, sqlQueryLogTable(tablename, opts))
+	if err != nil {
+		return dump, err
 	}
 	defer func() {
-		err = rows.Close()
-		if err != nil {
-			_, _ = fmt.Fprintln(w, err.Error())
+		closeErr := rows.Close()
+		if err == nil {
+			err = closeErr
 		}
-		return
 	}()
 
-	tw := tabwriter.NewWriter(w, 0, 0, 4, ' ', 0)
-
-	columns, err := rows.Columns()
+	dump.Columns, err = rows.Columns()
 	if err != nil {
-		_, _ = fmt.Fprintln(w, err.Error())
-		return
+		return dump, err
 	}
 	err = scanRowsOfAny(rows, func(row Row) error {
-		for i, value := range row {
-			var val interface{}
-			switch v := value.(type) {
-			case string:
-				val = repr.String(v)
-			default:
-				val = v
-			}
-			_, _ = fmt.Fprintln(tw, fmt.Sprintf("%s\t%v\t", columns[i], val))
-		}
-		_, _ = fmt.Fprintln(tw, "----------------\t------------\t")
+		dump.Rows = append(dump.Rows, opts.redaction.redactRow(dump.Columns, row))
 		return nil
 	})
+	if err != nil {
+		return dump, err
+	}
+	dump.RowsTotal, dump.RowsOmitted, err = countOmittedRows(ctx, dbi, tablename, opts, len(dump.Rows))
+	return dump, err
+}
 
+// Dump contents of table to stream in human-readable form
+func tableDumpPrettyPrint(ctx context.Context, w io.Writer, dbi QuerierExecer, tablename string, opts TableDumpOptions) {
+	dump, err := queryTableDump(ctx, dbi, tablename, opts)
 	if err != nil {
-		_, _ = fmt.Fprintln(w, err.Error())
+		_, _ = fmt.Fprintln(w, tablename+": "+err.Error())
 		return
 	}
-	_ = tw.Flush()
+	renderTableDump(w, dump, opts)
+}
+
+func renderTableDump(w io.Writer, dump TableDump, opts TableDumpOptions) {
+	tablename := dump.Name
+	renderer := tableRendererOrNil(opts.Format)
+	if renderer == nil {
+		_, _ = fmt.Fprintln(w, tablename+": unknown format "+opts.Format)
+		renderer = VerticalTableRenderer{}
+	}
+	err := renderer.Render(w, dump)
+	if err != nil {
+		_, _ = fmt.Fprintln(w, tablename+": "+err.Error())
+	}
 }
 
 // Dump contents of table to logger
-func tableDumpStructured(ctx context.Context, logger logrus.FieldLogger, level logrus.Level, dbi QuerierExecer, tablename string) {
-	qry := sqlQueryLogTable(tablename)
-	rows, err := dbi.QueryContext(ctx, qry)
+func tableDumpStructured(ctx context.Context, logger logrus.FieldLogger, level logrus.Level, dbi QuerierExecer, tablename string, opts TableDumpOptions) {
+	dump, err := queryTableDump(ctx, dbi, tablename, opts)
 	if err != nil {
 		logger.Warning("Unable to log table " + tablename + ": " + err.Error())
 		return
 	}
-	defer func() {
-		err = rows.Close()
-		if err != nil {
-			logger.Warning("Problem in rows.Close() when logging table " + tablename + ": " + err.Error())
-		}
-		return
-	}()
+	logTableDump(logger, level, dump, opts)
+}
 
-	columns, err := rows.Columns()
-	if err != nil {
-		logger.Warning("Problem in rows.Columns() when logging table " + tablename + ": " + err.Error())
+func logTableDump(logger logrus.FieldLogger, level logrus.Level, dump TableDump, opts TableDumpOptions) {
+	if opts.SingleEntry && logSingleEntry(logger, level, dump, opts) {
 		return
 	}
-	err = scanRowsOfAny(rows, func(row Row) error {
+	for _, row := range dump.Rows {
 		fields := make(logrus.Fields)
 		for i, value := range row {
-			fields[columns[i]] = value
+			fields[dump.Columns[i]] = value
 		}
 		logAtLevel(logger.WithFields(fields), level, "")
-		return nil
-	})
-	if err != nil {
-		logger.Warning("Problem in scanning rows when logging table " + tablename + ": " + err.Error())
 	}
+	if dump.RowsOmitted > 0 {
+		logAtLevel(logger.WithFields(logrus.Fields{
+			"rows_total":   dump.RowsTotal,
+			"rows_omitted": dump.RowsOmitted,
+		}), level, "")
+	}
+}
 
+// logSingleEntry logs the dump as a single entry, unless it is too large
+func logSingleEntry(logger logrus.FieldLogger, level logrus.Level, dump TableDump, opts TableDumpOptions) bool {
+	rows := make([]map[string]any, len(dump.Rows))
+	for i, row := range dump.Rows {
+		rows[i] = make(map[string]any, len(row))
+		for j, value := range row {
+			rows[i][dump.Columns[j]] = value
+		}
+	}
+	maxBytes := opts.SingleEntryMaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultSingleEntryMaxBytes
+	}
+	encoded, err := json.Marshal(rows)
+	if err != nil || len(encoded) > maxBytes {
+		return false
+	}
+	fields := logrus.Fields{
+		"table":     dump.Name,
+		"columns":   dump.Columns,
+		"rows":      rows,
+		"row_count": len(dump.Rows),
+	}
+	if dump.RowsOmitted > 0 {
+		fields["rows_total"] = dump.RowsTotal
+		fields["rows_omitted"] = dump.RowsOmitted
+	}
+	logAtLevel(logger.WithFields(fields), level, "")
+	return true
 }
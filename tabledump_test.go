@@ -0,0 +1,103 @@
+package sqllogging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTableDump(t *testing.T) {
+	defaults := TableDumpOptions{Limit: 10}
+	tests := []struct {
+		msg       string
+		ok        bool
+		tablename string
+		opts      TableDumpOptions
+		query     string
+	}{
+		{msg: "##log1", ok: true, tablename: "##log1", opts: defaults,
+			query: "select top(10) * from [##log1] order by 1"},
+		{msg: "##log1 limit=50 order=[b desc, a]", ok: true, tablename: "##log1",
+			opts:  TableDumpOptions{Limit: 50, OrderBy: []string{"b desc", " a"}},
+			query: "select top(50) * from [##log1] order by [b] desc, [a]"},
+		{msg: "##log1 order=[none] sample=[random]", ok: true, tablename: "##log1",
+			opts:  TableDumpOptions{Limit: 10, Unordered: true, Random: true},
+			query: "select * from (select top(10) * from [##log1] order by newid()) sample"},
+		{msg: "##log1 order=[x]]y] ", ok: true, tablename: "##log1",
+			opts:  TableDumpOptions{Limit: 10, OrderBy: []string{"x]y"}},
+			query: "select top(10) * from [##log1] order by [x]]y]"},
+		{msg: "##log1 and some text", ok: false},
+		{msg: "#log1", ok: false},
+		{msg: "hello", ok: false},
+	}
+	for _, tc := range tests {
+		tablename, opts, ok := parseTableDump(tc.msg, defaults)
+		assert.Equal(t, tc.ok, ok, tc.msg)
+		if ok {
+			assert.Equal(t, tc.tablename, tablename, tc.msg)
+			assert.Equal(t, tc.opts, opts, tc.msg)
+			assert.Equal(t, tc.query, sqlQueryLogTable(tablename, opts), tc.msg)
+		}
+	}
+	assert.Equal(t, "select top(1000) * from [##x] order by 1", sqlQueryLogTable("##x", TableDumpOptions{}))
+
+	// As concatenated by [code].log @table='#log1', @msg='limit=50 order=[b desc]'
+	fields, text := parseFields("sqlnestlevel=1 sqlspid=53 sqltime=[2000-01-01T00:00:00Z] ##log1 limit=50 order=[b desc] ")
+	assert.Equal(t, 53, fields["sqlspid"])
+	tablename, opts, ok := parseTableDump(text, defaults)
+	assert.True(t, ok)
+	assert.Equal(t, "##log1", tablename)
+	assert.Equal(t, TableDumpOptions{Limit: 50, OrderBy: []string{"b desc"}}, opts)
+}
+
+func TestLogSingleEntry(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	dump := TableDump{
+		Name:    "##log1",
+		Columns: []string{"x", "level"},
+		Rows:    []Row{{1, "a"}, {2, "b"}},
+	}
+	_, opts, _ := parseTableDump("##log1 mode=[single]", TableDumpOptions{})
+	assert.True(t, opts.SingleEntry)
+	assert.True(t, logSingleEntry(log, logrus.InfoLevel, dump, opts))
+	assert.Equal(t,
+		`{"columns":["x","level"],"level":"info","msg":"","row_count":2,"rows":[{"level":"a","x":1},{"level":"b","x":2}],"table":"##log1"}`+"\n",
+		logbuf.String())
+
+	logbuf.Reset()
+	opts.SingleEntryMaxBytes = 10
+	assert.False(t, logSingleEntry(log, logrus.InfoLevel, dump, opts))
+	assert.Equal(t, "", logbuf.String())
+}
+
+func TestConvertColumnValue(t *testing.T) {
+	t0 := time.Date(2000, 1, 1, 12, 0, 0, 0, time.FixedZone("", 3600))
+	tests := []struct {
+		typeName string
+		value    any
+		expected any
+	}{
+		{"DECIMAL", []byte("12345678901234567890.123"), json.Number("12345678901234567890.123")},
+		{"MONEY", []byte("1.2300"), json.Number("1.2300")},
+		{"VARBINARY", []byte{0xde, 0xad, 0x00}, "0xDEAD00"},
+		{"UNIQUEIDENTIFIER",
+			[]byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x78, 0x56, 0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0},
+			"12345678-1234-5678-1234-56789ABCDEF0"},
+		{"NVARCHAR", "hello", "hello"},
+		{"VARCHAR", []byte("hello"), "hello"},
+		{"BIGINT", int64(42), 42},
+		{"DATETIMEOFFSET", t0, t0},
+		{"INT", nil, nil},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, convertColumnValue(tc.typeName, tc.value), tc.typeName)
+	}
+}
@@ -0,0 +1,251 @@
+package sqllogging
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alecthomas/repr"
+)
+
+// TableRenderer renders "stderr:" table dumps. The renderer is chosen by
+// TableDumpOptions.Format, which SQL may override with format=[name].
+type TableRenderer interface {
+	Render(w io.Writer, dump TableDump) error
+}
+
+var (
+	tableRenderersMu sync.RWMutex
+	tableRenderers   = map[string]TableRenderer{
+		"":         VerticalTableRenderer{},
+		"vertical": VerticalTableRenderer{},
+		"grid":     GridTableRenderer{MaxWidth: 40},
+		"markdown": MarkdownTableRenderer{},
+		"csv":      CSVTableRenderer{},
+		"jsonl":    JSONLinesTableRenderer{},
+	}
+)
+
+// RegisterTableRenderer makes renderer available as format=[name]; this
+// may also be used to replace the built-in renderers.
+func RegisterTableRenderer(name string, renderer TableRenderer) {
+	tableRenderersMu.Lock()
+	defer tableRenderersMu.Unlock()
+	tableRenderers[name] = renderer
+}
+
+func tableRendererOrNil(name string) TableRenderer {
+	tableRenderersMu.RLock()
+	defer tableRenderersMu.RUnlock()
+	return tableRenderers[name]
+}
+
+func omittedNotice(dump TableDump) string {
+	return fmt.Sprintf("(%d of %d rows omitted)", dump.RowsOmitted, dump.RowsTotal)
+}
+
+// formatCell formats values for the renderers of plain text tables
+func formatCell(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// VerticalTableRenderer renders each row as lines of column and value,
+// followed by a separator line. This is the default.
+type VerticalTableRenderer struct{}
+
+func (VerticalTableRenderer) Render(w io.Writer, dump TableDump) error {
+	_, _ = fmt.Fprintln(w, "================================")
+	_, _ = fmt.Fprintln(w, dump.Name)
+	_, _ = fmt.Fprintln(w, "================================")
+
+	tw := tabwriter.NewWriter(w, 0, 0, 4, ' ', 0)
+	for _, row := range dump.Rows {
+		for i, value := range row {
+			var val interface{}
+			switch v := value.(type) {
+			case string:
+				val = repr.String(v)
+			default:
+				val = v
+			}
+			_, _ = fmt.Fprintln(tw, fmt.Sprintf("%s\t%v\t", dump.Columns[i], val))
+		}
+		_, _ = fmt.Fprintln(tw, "----------------\t------------\t")
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	if dump.RowsOmitted > 0 {
+		_, err = fmt.Fprintln(w, omittedNotice(dump))
+	}
+	return err
+}
+
+// GridTableRenderer renders a horizontal grid, like psql does. Values
+// longer than MaxWidth characters are cut; 0 means no limit.
+type GridTableRenderer struct {
+	MaxWidth int
+}
+
+func (g GridTableRenderer) cell(value any) string {
+	s := strings.NewReplacer("\r", `\r`, "\n", `\n`, "\t", `\t`).Replace(formatCell(value))
+	if g.MaxWidth > 0 && utf8.RuneCountInString(s) > g.MaxWidth {
+		runes := []rune(s)
+		s = string(runes[:max(g.MaxWidth-1, 0)]) + "…"
+	}
+	return s
+}
+
+func (g GridTableRenderer) Render(w io.Writer, dump TableDump) error {
+	cells := make([][]string, 0, len(dump.Rows)+1)
+	header := make([]string, len(dump.Columns))
+	for i, column := range dump.Columns {
+		header[i] = g.cell(column)
+	}
+	cells = append(cells, header)
+	for _, row := range dump.Rows {
+		line := make([]string, len(row))
+		for i, value := range row {
+			line[i] = g.cell(value)
+		}
+		cells = append(cells, line)
+	}
+
+	widths := make([]int, len(dump.Columns))
+	for _, line := range cells {
+		for i, cell := range line {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(dump.Name + "\n")
+	for n, line := range cells {
+		for i, cell := range line {
+			if i > 0 {
+				b.WriteString("|")
+			}
+			b.WriteString(" " + cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + " ")
+		}
+		b.WriteString("\n")
+		if n == 0 {
+			for i, width := range widths {
+				if i > 0 {
+					b.WriteString("+")
+				}
+				b.WriteString(strings.Repeat("-", width+2))
+			}
+			b.WriteString("\n")
+		}
+	}
+	if dump.RowsOmitted > 0 {
+		b.WriteString(omittedNotice(dump) + "\n")
+	} else {
+		fmt.Fprintf(&b, "(%d rows)\n", len(dump.Rows))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// MarkdownTableRenderer renders a Markdown table, e.g. for pasting into tickets.
+type MarkdownTableRenderer struct{}
+
+func (MarkdownTableRenderer) Render(w io.Writer, dump TableDump) error {
+	escape := strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>")
+	var b strings.Builder
+	b.WriteString("**" + dump.Name + "**\n\n|")
+	for _, column := range dump.Columns {
+		b.WriteString(" " + escape.Replace(column) + " |")
+	}
+	b.WriteString("\n|")
+	for range dump.Columns {
+		b.WriteString(" --- |")
+	}
+	b.WriteString("\n")
+	for _, row := range dump.Rows {
+		b.WriteString("|")
+		for _, value := range row {
+			b.WriteString(" " + escape.Replace(formatCell(value)) + " |")
+		}
+		b.WriteString("\n")
+	}
+	if dump.RowsOmitted > 0 {
+		b.WriteString("\n" + omittedNotice(dump) + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// CSVTableRenderer renders CSV with a header line; NULL is rendered as an
+// empty value.
+type CSVTableRenderer struct{}
+
+func (CSVTableRenderer) Render(w io.Writer, dump TableDump) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write(dump.Columns)
+	if err != nil {
+		return err
+	}
+	for _, row := range dump.Rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			if value != nil {
+				record[i] = formatCell(value)
+			}
+		}
+		err = cw.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// JSONLinesTableRenderer renders one JSON object per row, with the keys in
+// column order. If rows were omitted, a final object with rows_total and
+// rows_omitted is written.
+type JSONLinesTableRenderer struct{}
+
+func (JSONLinesTableRenderer) Render(w io.Writer, dump TableDump) error {
+	var b strings.Builder
+	for _, row := range dump.Rows {
+		b.WriteString("{")
+		for i, value := range row {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			key, err := json.Marshal(dump.Columns[i])
+			if err != nil {
+				return err
+			}
+			val, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			b.Write(key)
+			b.WriteString(":")
+			b.Write(val)
+		}
+		b.WriteString("}\n")
+	}
+	if dump.RowsOmitted > 0 {
+		fmt.Fprintf(&b, "{\"rows_total\":%d,\"rows_omitted\":%d}\n", dump.RowsTotal, dump.RowsOmitted)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
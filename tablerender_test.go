@@ -0,0 +1,73 @@
+package sqllogging
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableRenderers(t *testing.T) {
+	dump := TableDump{
+		Name:    "##log1",
+		Columns: []string{"x", "y"},
+		Rows:    []Row{{1, "number 1"}, {2, "a|b,\"c\""}, {3, nil}},
+	}
+	render := func(r TableRenderer, dump TableDump) string {
+		var buf bytes.Buffer
+		require.NoError(t, r.Render(&buf, dump))
+		return buf.String()
+	}
+
+	// NOTE: whitespace-sensitive
+	assert.Equal(t, `================================
+##log1
+================================
+x                   1               
+y                   "number 1"      
+----------------    ------------    
+x                   2               
+y                   "a|b,\"c\""     
+----------------    ------------    
+x                   3               
+y                   <nil>           
+----------------    ------------    
+`, render(VerticalTableRenderer{}, dump))
+
+	assert.Equal(t, `##log1
+ x | y        
+---+----------
+ 1 | number 1 
+ 2 | a|b,"c"  
+ 3 | NULL     
+(3 rows)
+`, render(GridTableRenderer{}, dump))
+
+	assert.Equal(t, `##log1
+ x | y    
+---+------
+ 1 | num… 
+ 2 | a|b… 
+ 3 | NULL 
+(10 of 13 rows omitted)
+`, render(GridTableRenderer{MaxWidth: 4}, TableDump{Name: dump.Name, Columns: dump.Columns, Rows: dump.Rows, RowsTotal: 13, RowsOmitted: 10}))
+
+	assert.Equal(t, "**##log1**\n\n"+`| x | y |
+| --- | --- |
+| 1 | number 1 |
+| 2 | a\|b,"c" |
+| 3 | NULL |
+`, render(MarkdownTableRenderer{}, dump))
+
+	assert.Equal(t, `x,y
+1,number 1
+2,"a|b,""c"""
+3,
+`, render(CSVTableRenderer{}, dump))
+
+	assert.Equal(t, `{"x":1,"y":"number 1"}
+{"x":2,"y":"a|b,\"c\""}
+{"x":3,"y":null}
+`, render(JSONLinesTableRenderer{}, dump))
+}
@@ -0,0 +1,89 @@
+package sqllogging
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TransactionTracker turns the driver's LogTransaction messages into
+// structured "transaction begin", "transaction commit" and "transaction
+// rollback" log entries. Every transaction gets a sequence id, and all log
+// entries written through the context while the transaction is open carry it
+// in the "sql_transaction" field; this way one can tell which SQL log lines
+// belong to a transaction that was later rolled back.
+//
+// Include msdsn.LogTransaction in the "log=" DSN parameter and attach the
+// tracker with WithTransactionTracker. Note that the driver only reports
+// transactions that are started, committed or rolled back at the outermost
+// level; savepoints and nested "begin transaction" are not visible.
+type TransactionTracker struct {
+	Level logrus.Level
+
+	mu      sync.Mutex
+	seq     int
+	current int // sequence id of the open transaction; 0 if none
+}
+
+// NewTransactionTracker returns a TransactionTracker logging at debug level.
+func NewTransactionTracker() *TransactionTracker {
+	return &TransactionTracker{Level: logrus.DebugLevel}
+}
+
+// WithTransactionTracker attaches tracker to ctx; it is used by LogrusLogger.
+func WithTransactionTracker(ctx context.Context, tracker *TransactionTracker) context.Context {
+	return context.WithValue(ctx, ckTransactionTracker, tracker)
+}
+
+func transactionTrackerOrNil(ctx context.Context) *TransactionTracker {
+	val := ctx.Value(ckTransactionTracker)
+	if val == nil {
+		return nil
+	}
+	return val.(*TransactionTracker)
+}
+
+// withFields adds the id of the open transaction, if any, to logger.
+func (t *TransactionTracker) withFields(logger logrus.FieldLogger) logrus.FieldLogger {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current == 0 {
+		return logger
+	}
+	return logger.WithField("sql_transaction", t.current)
+}
+
+func (t *TransactionTracker) log(logger logrus.FieldLogger, msg string) {
+	// The driver logs "BEGIN TRANSACTION <descriptor>", "COMMIT TRANSACTION <descriptor>"
+	// and "ROLLBACK TRANSACTION <descriptor>"
+	verb, rest, _ := strings.Cut(msg, " TRANSACTION")
+	descriptor := strings.TrimSpace(rest)
+
+	t.mu.Lock()
+	var event string
+	switch verb {
+	case "BEGIN":
+		t.seq++
+		t.current = t.seq
+		event = "transaction begin"
+	case "COMMIT":
+		event = "transaction commit"
+	case "ROLLBACK":
+		event = "transaction rollback"
+	default:
+		t.mu.Unlock()
+		return
+	}
+	fields := logrus.Fields{"sql_transaction_descriptor": descriptor}
+	if t.current != 0 {
+		fields["sql_transaction"] = t.current
+	}
+	if verb != "BEGIN" {
+		t.current = 0
+	}
+	t.mu.Unlock()
+
+	logAtLevel(logger.WithFields(fields), t.Level, event)
+}
@@ -0,0 +1,40 @@
+package sqllogging
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/denisenkom/go-mssqldb/msdsn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionTracker(t *testing.T) {
+	var logbuf bytes.Buffer
+	log := logrus.New()
+	log.Out = &logbuf
+	log.Level = logrus.DebugLevel
+	log.Formatter = &logrus.JSONFormatter{DisableTimestamp: true}
+
+	ctx := WithTransactionTracker(context.Background(), NewTransactionTracker())
+	logger := LogrusLogger{Logger: log, Fallback: StandardFallbackLogrusMssqlLogger{}}
+
+	logger.Log(ctx, msdsn.LogMessages, "info:before")
+	logger.Log(ctx, msdsn.LogTransaction, "BEGIN TRANSACTION 1f00000001")
+	logger.Log(ctx, msdsn.LogMessages, "info:a=1 inside")
+	logger.Log(ctx, msdsn.LogTransaction, "ROLLBACK TRANSACTION 1f00000001")
+	logger.Log(ctx, msdsn.LogTransaction, "BEGIN TRANSACTION 2f00000001")
+	logger.Log(ctx, msdsn.LogTransaction, "COMMIT TRANSACTION 2f00000001")
+	logger.Log(ctx, msdsn.LogMessages, "info:after")
+
+	assert.Equal(t, ``+
+		`{"level":"info","msg":"before"}
+{"level":"debug","msg":"transaction begin","sql_transaction":1,"sql_transaction_descriptor":"1f00000001"}
+{"a":1,"level":"info","msg":"inside","sql_transaction":1}
+{"level":"debug","msg":"transaction rollback","sql_transaction":1,"sql_transaction_descriptor":"1f00000001"}
+{"level":"debug","msg":"transaction begin","sql_transaction":2,"sql_transaction_descriptor":"2f00000001"}
+{"level":"debug","msg":"transaction commit","sql_transaction":2,"sql_transaction_descriptor":"2f00000001"}
+{"level":"info","msg":"after"}
+`, logbuf.String())
+}